@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -80,8 +82,14 @@ func main() {
 
 	fmt.Printf("endLat: %v, endLon: %v\n", endLat, endLon)
 
-	// WebSocket server address
-	serverAddr := "ws://localhost:8080/ws"
+	// WebSocket server address, identified by this machine's device ID and
+	// publishing into the channel named by LOCASTREAM_CHANNEL, if any
+	id := deviceID()
+	query := url.Values{"deviceId": {id}}
+	if channel := os.Getenv("LOCASTREAM_CHANNEL"); channel != "" {
+		query.Set("channel", channel)
+	}
+	serverAddr := "ws://localhost:8080/ws/publish?" + query.Encode()
 
 	// Get detailed route information from the API
 	routeDetails, err := getRouteDetails(startLat, startLon, endLat, endLon)
@@ -95,7 +103,17 @@ func main() {
 
 	// Establish a WebSocket connection to the server
 	u, _ := url.Parse(serverAddr)
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	header := http.Header{}
+	if token := os.Getenv("LOCASTREAM_TOKEN"); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	// Share the planned route so the server can report remaining distance and ETA
+	if err := uploadRoute(routeDetails, id, query.Get("channel"), header); err != nil {
+		log.Printf("Error uploading route: %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
 	if err != nil {
 		log.Fatalf("Error connecting to WebSocket server: %v", err)
 	}
@@ -136,6 +154,57 @@ func main() {
 	fmt.Println("Client stopped.")
 }
 
+// deviceID returns the identity this client publishes under, taken from the
+// LOCASTREAM_DEVICE_ID environment variable or the machine's hostname
+func deviceID() string {
+	if id := os.Getenv("LOCASTREAM_DEVICE_ID"); id != "" {
+		return id
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
+// uploadRoute stores the planned route on the server as the device's route.
+// Routes belong to a device, so there is nothing to upload without an ID
+func uploadRoute(route RouteDetails, deviceID, channel string, header http.Header) error {
+	if deviceID == "" {
+		return errors.New("no device ID, set LOCASTREAM_DEVICE_ID to share the route")
+	}
+
+	body, err := json.Marshal(struct {
+		Channel     string      `json:"channel,omitempty"`
+		Coordinates [][]float64 `json:"coordinates"`
+	}{channel, route.Coordinates})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/devices/"+url.PathEscape(deviceID)+"/route", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server replied %s", resp.Status)
+	}
+
+	return nil
+}
+
 func getRouteDetails(startLat, startLon, endLat, endLon float64) (RouteDetails, error) {
 	start := fmt.Sprintf("%.6f,%.6f", startLon, startLat)
 	end := fmt.Sprintf("%.6f,%.6f", endLon, endLat)
@@ -337,6 +406,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -446,6 +516,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -535,6 +606,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -5,8 +5,17 @@ import (
 	"log"
 	"os"
 	"os/signal"
-
+	"path/filepath"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/api"
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/config"
+	"github.com/nihankhan/locastream/internal/geofence"
+	"github.com/nihankhan/locastream/internal/route"
 	"github.com/nihankhan/locastream/internal/router"
+	"github.com/nihankhan/locastream/internal/store"
+	"github.com/nihankhan/locastream/internal/webhook"
 
 	"github.com/valyala/fasthttp"
 )
@@ -39,6 +48,9 @@ func (s *Server) Start(addr string) {
 
 	fmt.Println("Shuting down server...")
 
+	// Long-lived streams would otherwise keep the shutdown waiting
+	api.CloseConnections()
+
 	if err := s.fastHttpServer.Shutdown(); err != nil {
 		log.Fatal(err)
 	}
@@ -46,12 +58,124 @@ func (s *Server) Start(addr string) {
 	fmt.Println("Server gracefully stopped!")
 }
 
+// authenticator builds the token verifier from the configuration, or returns nil
+// if authentication is disabled
+func authenticator(cfg *config.Config) (auth.Verifier, error) {
+	var chain auth.Chain
+
+	if cfg.APIKeysFile != "" {
+		keys, err := auth.LoadStaticKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+
+	if cfg.JWTSecret != "" {
+		chain = append(chain, &auth.JWTVerifier{
+			Secret: []byte(cfg.JWTSecret),
+			Issuer: cfg.JWTIssuer,
+			Leeway: 30 * time.Second,
+		})
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+
+	return chain, nil
+}
+
+// historyQueueSize is the number of location updates waiting to be written to
+// the history store before new ones are dropped
+const historyQueueSize = 4096
+
+// openHistory opens the configured history backend, or returns nil if history
+// is disabled
+func openHistory(cfg *config.Config) (store.Store, error) {
+	switch cfg.History {
+	case "none", "":
+		return nil, nil
+	case "file":
+		return store.OpenFileStore(filepath.Join(cfg.DataDir, "history"), store.DefaultSegmentSize)
+	case "bolt":
+		if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+			return nil, err
+		}
+		return store.OpenBoltStore(filepath.Join(cfg.DataDir, "history.db"))
+	default:
+		return nil, fmt.Errorf("unknown history backend %q", cfg.History)
+	}
+}
+
 func main() {
+	cfg := config.Load()
+
+	overflow, err := api.ParseOverflowPolicy(cfg.Overflow)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.SendQueueSize < 1 {
+		log.Fatal("send queue size must be at least 1")
+	}
+
+	api.SendQueueSize = cfg.SendQueueSize
+	api.Overflow = overflow
+
+	verifier, err := authenticator(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.Authenticator = verifier
+
+	origins, err := api.ParseOriginPolicy(cfg.AllowedOrigins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.Origins = origins
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if history != nil {
+		api.History = store.NewAsync(history, historyQueueSize)
+		defer api.History.Close()
+	}
+
+	fences, err := geofence.Open(filepath.Join(cfg.DataDir, "geofences.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.Geofences = fences
+
+	routes, err := route.Open(filepath.Join(cfg.DataDir, "routes.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.Routes = routes
+
+	hooks, err := webhook.Open(filepath.Join(cfg.DataDir, "webhooks.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	api.Webhooks = webhook.NewDispatcher(hooks, filepath.Join(cfg.DataDir, "webhooks-dead-letter.jsonl"))
+	defer api.Webhooks.Close()
+
+	// Devices that stop sending updates are reported offline
+	done := make(chan struct{})
+	defer close(done)
+	go api.MonitorDevices(done)
+	go api.PublishClusters(done)
+
 	r := router.Routers()
 
 	server := NewServer(r.Handler)
 
-	server.Start(":8080")
+	server.Start(cfg.Addr)
 }
 
 ///   24.242366448279004, 90.8678031733911
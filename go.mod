@@ -5,15 +5,18 @@ go 1.21.6
 require (
 	github.com/fasthttp/router v1.5.0
 	github.com/fasthttp/websocket v1.5.8
+	github.com/gorilla/websocket v1.5.1
 	github.com/valyala/fasthttp v1.52.0
-
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
 	github.com/andybalholm/brotli v1.1.0 // indirect
-	github.com/gorilla/websocket v1.5.1 // indirect
 	github.com/klauspost/compress v1.17.8 // indirect
 	github.com/savsgio/gotils v0.0.0-20240303185622-093b76447511 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
 )
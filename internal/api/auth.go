@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// Authenticator verifies the bearer tokens of connections and requests.
+// Authentication is disabled while it is nil
+var Authenticator auth.Verifier
+
+// bearerProtocol is offered in Sec-WebSocket-Protocol by browsers, which can't
+// set headers, followed by the token as the next protocol entry
+const bearerProtocol = "bearer"
+
+var errMissingToken = errors.New("missing token")
+
+// requestToken returns the bearer token of a request, taken from the
+// Authorization header, the access_token query parameter or the
+// Sec-WebSocket-Protocol header, and whether it came from the latter
+func requestToken(ctx *fasthttp.RequestCtx) (string, bool) {
+	if header := string(ctx.Request.Header.Peek("Authorization")); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), false
+		}
+	}
+
+	if token := string(ctx.QueryArgs().Peek("access_token")); token != "" {
+		return token, false
+	}
+
+	protocols := strings.Split(string(ctx.Request.Header.Peek("Sec-WebSocket-Protocol")), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == bearerProtocol {
+			return strings.TrimSpace(protocols[i+1]), true
+		}
+	}
+
+	return "", false
+}
+
+// authenticate returns the principal behind a request, or the anonymous
+// principal if authentication is disabled
+func authenticate(ctx *fasthttp.RequestCtx) (*auth.Principal, error) {
+	token, viaProtocol := requestToken(ctx)
+
+	// Browsers drop the connection unless one of the offered protocols is selected
+	if viaProtocol {
+		ctx.Response.Header.Set("Sec-WebSocket-Protocol", bearerProtocol)
+	}
+
+	if Authenticator == nil {
+		return auth.Anonymous(), nil
+	}
+
+	if token == "" {
+		return nil, errMissingToken
+	}
+
+	return Authenticator.Verify(token)
+}
+
+// authenticateRequest authenticates a request, replying 401 if it fails
+func authenticateRequest(ctx *fasthttp.RequestCtx) (*auth.Principal, bool) {
+	principal, err := authenticate(ctx)
+	if err != nil {
+		log.Printf("Authentication failed for %s: %v", ctx.RemoteAddr(), err)
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="locastream"`)
+		writeError(ctx, fasthttp.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	return principal, true
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultChannel is used by connections that don't name a channel
+const DefaultChannel = "default"
+
+// maxChannelsPerClient limits how many channels a single subscriber can join
+const maxChannelsPerClient = 32
+
+// channelPattern restricts channel names to characters that are safe in URLs and logs
+var channelPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// Map of channel name to the clients receiving its broadcasts, guarded by connectionsMutex
+var channels = make(map[string]map[*Client]struct{})
+
+// ChannelInfo describes a channel and its member counts
+type ChannelInfo struct {
+	Name        string `json:"name"`
+	Publishers  int    `json:"publishers"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// validateChannel reports an error if the channel name is not acceptable
+func validateChannel(name string) error {
+	if !channelPattern.MatchString(name) {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+
+	return nil
+}
+
+// requestChannels returns the channels named by the channel query parameter,
+// which may be repeated or hold a comma-separated list, or the default channel
+func requestChannels(ctx *fasthttp.RequestCtx) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, value := range ctx.QueryArgs().PeekMulti("channel") {
+		for _, name := range strings.Split(string(value), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+
+			if err := validateChannel(name); err != nil {
+				return nil, err
+			}
+
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return []string{DefaultChannel}, nil
+	}
+
+	if len(names) > maxChannelsPerClient {
+		return nil, fmt.Errorf("too many channels, the limit is %d", maxChannelsPerClient)
+	}
+
+	return names, nil
+}
+
+// JoinChannel adds the client to the members of a channel
+func JoinChannel(client *Client, name string) error {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	if _, ok := client.channels[name]; ok {
+		return nil
+	}
+
+	if len(client.channels) >= maxChannelsPerClient {
+		return fmt.Errorf("too many channels, the limit is %d", maxChannelsPerClient)
+	}
+
+	joinChannel(client, name)
+
+	// Catch the client up on the channel it just joined
+	client.sendSnapshot(map[string]struct{}{name: {}})
+
+	return nil
+}
+
+// LeaveChannel removes the client from the members of a channel
+func LeaveChannel(client *Client, name string) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	leaveChannel(client, name)
+}
+
+// joinChannel adds the client to a channel, connectionsMutex must be held
+func joinChannel(client *Client, name string) {
+	members, ok := channels[name]
+	if !ok {
+		members = make(map[*Client]struct{})
+		channels[name] = members
+	}
+
+	members[client] = struct{}{}
+	client.channels[name] = struct{}{}
+}
+
+// leaveChannel removes the client from a channel and drops the channel once it
+// has no members left, connectionsMutex must be held
+func leaveChannel(client *Client, name string) {
+	delete(client.channels, name)
+
+	for deviceID, channel := range client.inView {
+		if channel == name {
+			delete(client.inView, deviceID)
+		}
+	}
+
+	members, ok := channels[name]
+	if !ok {
+		return
+	}
+
+	delete(members, client)
+	if len(members) == 0 {
+		delete(channels, name)
+	}
+}
+
+// Channels returns every channel that has publishers or subscribers and that the
+// principal may access, sorted by name
+func Channels(principal *auth.Principal) []ChannelInfo {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	infos := make(map[string]*ChannelInfo)
+	info := func(name string) *ChannelInfo {
+		if _, ok := infos[name]; !ok {
+			infos[name] = &ChannelInfo{Name: name}
+		}
+		return infos[name]
+	}
+
+	for _, client := range connections {
+		switch client.role {
+		case RolePublisher:
+			info(client.channel).Publishers++
+		case RoleSubscriber:
+			for name := range client.channels {
+				info(name).Subscribers++
+			}
+		}
+	}
+
+	list := make([]ChannelInfo, 0, len(infos))
+	for _, i := range infos {
+		if principal.CanAccess(i.Name) {
+			list = append(list, *i)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// ListChannels serves the list of channels and their member counts
+func ListChannels(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, Channels(principal))
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+	"github.com/valyala/fasthttp"
+)
+
+// TypeClusters messages carry a ClusterSet
+const TypeClusters = "clusters"
+
+// MaxZoom is the highest web map zoom level subscribers can ask clusters for
+const MaxZoom = 24
+
+// Clustering settings
+var (
+	// ClusterMaxZoom is the zoom level from which subscribers that asked for
+	// clusters get individual devices instead
+	ClusterMaxZoom = 12
+
+	// ClusterInterval is how often subscribers get their clusters
+	ClusterInterval = time.Second
+)
+
+// Cluster is a group of devices close together at the subscriber's zoom
+// level, located at their centroid. DeviceID is set for a lone device
+type Cluster struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	DeviceID  string  `json:"deviceId,omitempty"`
+}
+
+// ClusterSet is every cluster a subscriber can see at its zoom level
+type ClusterSet struct {
+	Zoom     int       `json:"zoom"`
+	Clusters []Cluster `json:"clusters"`
+}
+
+// requestZoom sets the zoom level the client gets clusters for from the zoom
+// query parameter, if the request has one
+func (c *Client) requestZoom(ctx *fasthttp.RequestCtx) error {
+	value := ctx.QueryArgs().Peek("zoom")
+	if len(value) == 0 {
+		return nil
+	}
+
+	zoom, err := strconv.Atoi(string(value))
+	if err != nil {
+		return fmt.Errorf("zoom %q is not a number", value)
+	}
+
+	if err := validateZoom(zoom); err != nil {
+		return err
+	}
+
+	c.zoom = &zoom
+
+	return nil
+}
+
+// validateZoom reports an error if the zoom level is out of range
+func validateZoom(zoom int) error {
+	if zoom < 0 || zoom > MaxZoom {
+		return fmt.Errorf("zoom must be between 0 and %d", MaxZoom)
+	}
+
+	return nil
+}
+
+// clusteredLocked reports whether the client gets clusters instead of
+// positions, connectionsMutex must be held
+func (c *Client) clusteredLocked() bool {
+	return c.zoom != nil && *c.zoom < ClusterMaxZoom
+}
+
+// clusterView is what decides the clusters a client sees. Clients with the
+// same view share their clusters
+type clusterView struct {
+	zoom     int
+	channels map[string]struct{}
+	viewport *geo.BBox
+	devices  map[string]struct{}
+}
+
+// clusterViewLocked returns a copy of the client's view that stays valid after
+// connectionsMutex is released, connectionsMutex must be held
+func (c *Client) clusterViewLocked() clusterView {
+	view := clusterView{
+		zoom:     *c.zoom,
+		channels: make(map[string]struct{}, len(c.channels)),
+		devices:  c.devices,
+	}
+
+	for name := range c.channels {
+		view.channels[name] = struct{}{}
+	}
+
+	if c.viewport != nil {
+		box := *c.viewport
+		view.viewport = &box
+	}
+
+	return view
+}
+
+// key identifies the view, equal for views that see the same clusters
+func (v clusterView) key() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d|%s|", v.zoom, strings.Join(sortedKeys(v.channels), ","))
+
+	if v.viewport != nil {
+		fmt.Fprintf(&b, "%v", *v.viewport)
+	}
+
+	if v.devices != nil {
+		fmt.Fprintf(&b, "|%s", strings.Join(sortedKeys(v.devices), ","))
+	}
+
+	return b.String()
+}
+
+// clusters groups the positions seen through the view
+func (v clusterView) clusters(positions []*Envelope) ClusterSet {
+	clusterer := geo.NewClusterer(geo.ClusterCellSize(v.zoom))
+
+	for _, env := range positions {
+		if _, ok := v.channels[env.Channel]; !ok {
+			continue
+		}
+
+		if v.devices != nil {
+			if _, ok := v.devices[env.DeviceID]; !ok {
+				continue
+			}
+		}
+
+		if v.viewport != nil && !v.viewport.Contains(env.location.Latitude, env.location.Longitude) {
+			continue
+		}
+
+		clusterer.Add(env.DeviceID, env.location.Latitude, env.location.Longitude)
+	}
+
+	set := ClusterSet{Zoom: v.zoom, Clusters: []Cluster{}}
+	for _, cluster := range clusterer.Clusters() {
+		set.Clusters = append(set.Clusters, Cluster{
+			Latitude:  cluster.Lat,
+			Longitude: cluster.Lon,
+			Count:     cluster.Count,
+			DeviceID:  cluster.ID,
+		})
+	}
+
+	return set
+}
+
+// sendClustersLocked queues the clusters of the latest positions the client
+// can see, unless they haven't changed since the last ones it was sent.
+// connectionsMutex must be held
+func (c *Client) sendClustersLocked() {
+	set := c.clusterViewLocked().clusters(envelopesIn(c.channels, c.viewport))
+
+	env, err := NewEnvelope(TypeClusters, "", set)
+	if err != nil {
+		log.Println("Error building clusters envelope:", err)
+		return
+	}
+
+	c.pushClustersLocked(env, newFrameSet(env))
+}
+
+// pushClustersLocked queues a clusters envelope unless the client was already
+// sent the same clusters, connectionsMutex must be held
+func (c *Client) pushClustersLocked(env *Envelope, frames *frameSet) {
+	if bytes.Equal(env.Payload, c.clusters) {
+		return
+	}
+
+	frame, err := frames.frame(c.format)
+	if err != nil {
+		log.Println("Error encoding clusters envelope:", err)
+		return
+	}
+
+	c.clusters = env.Payload
+	c.queue.push(frame)
+}
+
+// PublishClusters sends subscribers that asked for clusters their updated
+// clusters every ClusterInterval until done is closed
+func PublishClusters(done <-chan struct{}) {
+	ticker := time.NewTicker(ClusterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			publishClusters()
+		}
+	}
+}
+
+// clusterGroup is the clients that share a view and the clusters they get
+type clusterGroup struct {
+	view    clusterView
+	clients []*Client
+	env     *Envelope
+}
+
+// publishClusters sends every clustering subscriber its clusters. The clients
+// and positions are read under connectionsMutex, but the clusters are built
+// once per distinct view after releasing it
+func publishClusters() {
+	connectionsMutex.Lock()
+
+	groups := make(map[string]*clusterGroup)
+	for _, client := range connections {
+		if !client.clusteredLocked() {
+			continue
+		}
+
+		view := client.clusterViewLocked()
+		key := view.key()
+
+		group, ok := groups[key]
+		if !ok {
+			group = &clusterGroup{view: view}
+			groups[key] = group
+		}
+		group.clients = append(group.clients, client)
+	}
+
+	var positions []*Envelope
+	if len(groups) > 0 {
+		positions = latestEnvelopes()
+	}
+
+	connectionsMutex.Unlock()
+
+	// The running centroids depend on the order positions are added in
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].DeviceID < positions[j].DeviceID
+	})
+
+	for _, group := range groups {
+		env, err := NewEnvelope(TypeClusters, "", group.view.clusters(positions))
+		if err != nil {
+			log.Println("Error building clusters envelope:", err)
+			continue
+		}
+		group.env = env
+	}
+
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	for key, group := range groups {
+		if group.env == nil {
+			continue
+		}
+
+		frames := newFrameSet(group.env)
+		for _, client := range group.clients {
+			// Clients that changed their view meanwhile were sent fresh clusters
+			if client.clusteredLocked() && client.clusterViewLocked().key() == key {
+				client.pushClustersLocked(group.env, frames)
+			}
+		}
+	}
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+func TestPublishClusters(t *testing.T) {
+	for i, id := range []string{"cl-a", "cl-b", "cl-c"} {
+		env, err := NewLocationEnvelope("cluster-test", Location{DeviceID: id, Latitude: 23.8 + float64(i)*0.1, Longitude: 90.4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordPosition(env, *env.location)
+	}
+	defer func() {
+		positionsMutex.Lock()
+		for _, id := range []string{"cl-a", "cl-b", "cl-c"} {
+			delete(latestPositions, id)
+			positionIndex.Remove(id)
+		}
+		positionsMutex.Unlock()
+	}()
+
+	newClient := func(zoom int, viewport *geo.BBox) *Client {
+		return &Client{
+			channels: map[string]struct{}{"cluster-test": {}},
+			zoom:     &zoom,
+			viewport: viewport,
+			queue:    newSendQueue(SendQueueSize, Overflow),
+		}
+	}
+
+	wide := newClient(3, nil)
+	alsoWide := newClient(3, nil)
+	near := newClient(11, nil)
+	boxed := newClient(3, &geo.BBox{MinLon: 90, MinLat: 23.85, MaxLon: 91, MaxLat: 24})
+	clients := []*Client{wide, alsoWide, near, boxed}
+
+	connectionsMutex.Lock()
+	connections = append(connections, clients...)
+	connectionsMutex.Unlock()
+	defer func() {
+		for _, c := range clients {
+			RemoveConnection(c)
+		}
+	}()
+
+	publishClusters()
+
+	received := func(c *Client) []ClusterSet {
+		items, _ := c.queue.pop(time.After(10 * time.Millisecond))
+
+		var sets []ClusterSet
+		for _, item := range items {
+			var env struct {
+				Type    string
+				Payload ClusterSet
+			}
+			if err := json.Unmarshal(item.data, &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type == TypeClusters {
+				sets = append(sets, env.Payload)
+			}
+		}
+
+		return sets
+	}
+
+	counts := func(set ClusterSet) []int {
+		var n []int
+		for _, c := range set.Clusters {
+			n = append(n, c.Count)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name   string
+		client *Client
+		counts []int
+	}{
+		{name: "zoomed out", client: wide, counts: []int{3}},
+		{name: "same view", client: alsoWide, counts: []int{3}},
+		{name: "zoomed in", client: near, counts: []int{1, 1, 1}},
+		{name: "viewport", client: boxed, counts: []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets := received(tt.client)
+			if len(sets) != 1 {
+				t.Fatalf("got %d cluster sets, want 1", len(sets))
+			}
+
+			if got := counts(sets[0]); !reflect.DeepEqual(got, tt.counts) {
+				t.Errorf("got cluster sizes %v, want %v", got, tt.counts)
+			}
+		})
+	}
+
+	// Nothing moved, so nobody is sent the same clusters again
+	publishClusters()
+
+	for _, c := range clients {
+		if sets := received(c); len(sets) != 0 {
+			t.Errorf("unchanged clusters sent again: %+v", sets)
+		}
+	}
+}
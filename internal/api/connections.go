@@ -0,0 +1,87 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// ConnectionInfo describes an open connection and the state of its send queue
+type ConnectionInfo struct {
+	ID          uint64    `json:"id"`
+	Transport   string    `json:"transport"`
+	Format      string    `json:"format,omitempty"`
+	Role        Role      `json:"role"`
+	DeviceID    string    `json:"deviceId,omitempty"`
+	Channel     string    `json:"channel,omitempty"`
+	Channels    []string  `json:"channels"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	MaxRate     float64   `json:"maxRate,omitempty"`
+	Queued      int       `json:"queued"`
+	Dropped     uint64    `json:"dropped"`
+}
+
+// Connections returns the open connections on channels the principal may
+// access, ordered by connection ID
+func Connections(principal *auth.Principal) []ConnectionInfo {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	list := make([]ConnectionInfo, 0, len(connections))
+	for _, client := range connections {
+		visible := client.channel != "" && principal.CanAccess(client.channel)
+
+		names := make([]string, 0, len(client.channels))
+		for name := range client.channels {
+			if principal.CanAccess(name) {
+				names = append(names, name)
+				visible = true
+			}
+		}
+		sort.Strings(names)
+
+		if !visible {
+			continue
+		}
+
+		queued, dropped := client.queue.stats()
+
+		list = append(list, ConnectionInfo{
+			ID:          client.id,
+			Transport:   client.transport,
+			Format:      client.format,
+			Role:        client.role,
+			DeviceID:    client.deviceID,
+			Channel:     client.channel,
+			Channels:    names,
+			ConnectedAt: client.connectedAt,
+			MaxRate:     client.maxRate,
+			Queued:      queued,
+			Dropped:     dropped,
+		})
+	}
+
+	return list
+}
+
+// ListConnections serves the list of open connections with their queue statistics
+func ListConnections(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, Connections(principal))
+}
+
+// CloseConnections closes every open connection, used when the server shuts down
+func CloseConnections() {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	for _, client := range connections {
+		client.queue.close()
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// Control message types subscribers can send
+const (
+	ControlJoin  = "join"
+	ControlLeave = "leave"
+
+	// ControlViewport sets the bounding box the subscriber receives positions
+	// in, or clears it when the message has none, and the zoom level it gets
+	// clusters for. Without a zoom the subscriber gets individual devices
+	ControlViewport = "viewport"
+
+	// ControlRate sets how many times per second at most the subscriber is
+	// sent updates, or lifts the limit when the message has none
+	ControlRate = "rate"
+)
+
+// ControlMessage is sent by subscribers to change what they receive
+type ControlMessage struct {
+	Type    string    `json:"type"`
+	Channel string    `json:"channel,omitempty"`
+	BBox    *geo.BBox `json:"bbox,omitempty"`
+	Zoom    *int      `json:"zoom,omitempty"`
+	MaxRate float64   `json:"maxRate,omitempty"`
+}
+
+// handleControl applies a control message sent by a subscriber
+func (c *Client) handleControl(msg message) {
+	var ctrl ControlMessage
+	if err := msg.unmarshal(&ctrl); err != nil || ctrl.Type == "" {
+		// Anything that isn't a control message is an attempt to publish
+		c.sendError(&ValidationError{Code: ErrCodeReadOnly, Message: "subscriber connections are read-only"})
+		return
+	}
+
+	switch ctrl.Type {
+	case ControlJoin:
+		if err := validateChannel(ctrl.Channel); err != nil {
+			c.sendError(&ValidationError{Code: ErrCodeInvalidChannel, Field: "channel", Message: err.Error()})
+			return
+		}
+
+		if !c.principal.CanAccess(ctrl.Channel) {
+			c.sendError(&ValidationError{Code: ErrCodeForbidden, Field: "channel", Message: "access to channel " + ctrl.Channel + " denied"})
+			return
+		}
+
+		if err := JoinChannel(c, ctrl.Channel); err != nil {
+			c.sendError(&ValidationError{Code: ErrCodeInvalidChannel, Field: "channel", Message: err.Error()})
+		}
+	case ControlLeave:
+		LeaveChannel(c, ctrl.Channel)
+	case ControlViewport:
+		if ctrl.BBox != nil {
+			if err := ctrl.BBox.Validate(); err != nil {
+				c.sendError(&ValidationError{Code: ErrCodeInvalidBBox, Field: "bbox", Message: err.Error()})
+				return
+			}
+		}
+
+		if ctrl.Zoom != nil {
+			if err := validateZoom(*ctrl.Zoom); err != nil {
+				c.sendError(&ValidationError{Code: ErrCodeOutOfRange, Field: "zoom", Message: err.Error()})
+				return
+			}
+		}
+
+		SetViewport(c, ctrl.BBox, ctrl.Zoom)
+	case ControlRate:
+		if err := validateRate(ctrl.MaxRate); err != nil {
+			c.sendError(&ValidationError{Code: ErrCodeOutOfRange, Field: "maxRate", Message: err.Error()})
+			return
+		}
+
+		SetMaxRate(c, ctrl.MaxRate)
+	default:
+		c.sendError(&ValidationError{
+			Code:    ErrCodeUnknownType,
+			Field:   "type",
+			Message: fmt.Sprintf("unknown control message type %q", ctrl.Type),
+		})
+	}
+}
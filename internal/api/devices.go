@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/nihankhan/locastream/internal/geo"
+	"github.com/valyala/fasthttp"
+)
+
+// ListDevices serves the latest position of every device the caller may see,
+// optionally limited to the channel named by the channel query parameter
+func ListDevices(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	channel := string(ctx.QueryArgs().Peek("channel"))
+
+	writeJSON(ctx, fasthttp.StatusOK, Positions(func(p DevicePosition) bool {
+		return principal.CanAccess(p.Channel) && (channel == "" || p.Channel == channel)
+	}))
+}
+
+// GetDevicePosition serves the latest position of the device named in the path
+func GetDevicePosition(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+
+	// Devices on inaccessible channels are reported as unknown so they can't be probed
+	position, ok := Position(deviceID)
+	if !ok || !principal.CanAccess(position.Channel) {
+		writeError(ctx, fasthttp.StatusNotFound, "unknown device "+deviceID)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, position)
+}
+
+// ListPositions serves the latest positions inside the bounding box given by
+// the bbox query parameter as minLon,minLat,maxLon,maxLat
+func ListPositions(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	box, err := geo.ParseBBox(string(ctx.QueryArgs().Peek("bbox")))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	channel := string(ctx.QueryArgs().Peek("channel"))
+
+	writeJSON(ctx, fasthttp.StatusOK, PositionsWithin(box, func(p DevicePosition) bool {
+		return principal.CanAccess(p.Channel) && (channel == "" || p.Channel == channel)
+	}))
+}
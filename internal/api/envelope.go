@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// MessageVersion is the version of the envelope format sent to clients
+const MessageVersion = 1
+
+// Message types carried in an Envelope
+const (
+	TypeLocation = "location"
+	TypeError    = "error"
+	TypeSnapshot = "snapshot"
+)
+
+// Envelope wraps every message the server sends so subscribers can order,
+// deduplicate and detect gaps, and tell message types apart. Broadcast
+// envelopes carry a server-wide event ID clients can resume from
+type Envelope struct {
+	ID         uint64          `json:"id,omitempty"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	Channel    string          `json:"channel,omitempty"`
+	DeviceID   string          `json:"deviceId,omitempty"`
+	Seq        uint64          `json:"seq,omitempty"`
+	ServerTime time.Time       `json:"serverTime"`
+	Payload    json.RawMessage `json:"payload" msgpack:"-"`
+
+	// Motion of the device and its progress along its planned route, on
+	// location messages
+	Motion *Motion        `json:"motion,omitempty"`
+	Route  *RouteProgress `json:"route,omitempty"`
+
+	// Location carried by location messages, for matching them against viewports
+	location *Location
+
+	// Value the payload was encoded from, so other wire formats can encode it
+	// directly. Nil on envelopes rebuilt from stored payloads
+	payload interface{}
+}
+
+// Define a mutex to safely access the per-device sequence numbers
+var sequencesMutex sync.Mutex
+
+// Map of device ID to the last sequence number assigned to it
+var sequences = make(map[string]uint64)
+
+// nextSequence returns the next sequence number for the device, starting at 1
+func nextSequence(deviceID string) uint64 {
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+
+	sequences[deviceID]++
+
+	return sequences[deviceID]
+}
+
+// forgetSequence drops the sequence number of a device that has not been
+// heard from in PositionTTL, so devices seen once don't pile up. A device heard
+// from again starts over at 1
+func forgetSequence(deviceID string) {
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+
+	delete(sequences, deviceID)
+}
+
+// NewEnvelope wraps the payload in an envelope of the given type, stamped with
+// the current server time
+func NewEnvelope(msgType, deviceID string, payload interface{}) (*Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Type:       msgType,
+		Version:    MessageVersion,
+		DeviceID:   deviceID,
+		ServerTime: time.Now().UTC(),
+		Payload:    data,
+		payload:    payload,
+	}, nil
+}
+
+// NewLocationEnvelope wraps an accepted location update posted into a channel
+// in an envelope carrying the device's next sequence number
+func NewLocationEnvelope(channel string, location Location) (*Envelope, error) {
+	env, err := NewEnvelope(TypeLocation, location.DeviceID, location)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Channel = channel
+	env.location = &location
+
+	env.Seq = nextSequence(location.DeviceID)
+
+	return env, nil
+}
@@ -0,0 +1,40 @@
+package api
+
+import "testing"
+
+func TestLocationEnvelopeSeq(t *testing.T) {
+	forgetDevices(t, "seq-a", "seq-b")
+
+	tests := []struct {
+		deviceID string
+		want     uint64
+	}{
+		{deviceID: "seq-a", want: 1},
+		{deviceID: "seq-a", want: 2},
+		{deviceID: "seq-b", want: 1},
+		{deviceID: "seq-a", want: 3},
+		{deviceID: "seq-b", want: 2},
+	}
+
+	for i, tt := range tests {
+		env, err := NewLocationEnvelope("seq-test", Location{DeviceID: tt.deviceID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if env.Seq != tt.want {
+			t.Errorf("update %d from %s has seq %d, want %d", i, tt.deviceID, env.Seq, tt.want)
+		}
+	}
+
+	// A device heard from again after it was forgotten starts over
+	forgetSequence("seq-a")
+
+	env, err := NewLocationEnvelope("seq-test", Location{DeviceID: "seq-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Seq != 1 {
+		t.Errorf("forgotten device has seq %d, want 1", env.Seq)
+	}
+}
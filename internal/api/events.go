@@ -0,0 +1,117 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/webhook"
+)
+
+// EventChannelSuffix names the channel events about a channel's devices are
+// broadcast on, e.g. fleet-a:events
+const EventChannelSuffix = auth.EventChannelSuffix
+
+// EventChannel returns the channel events about the channel's devices are broadcast on
+func EventChannel(channel string) string {
+	return channel + EventChannelSuffix
+}
+
+// isEventChannel reports whether the channel carries events rather than positions
+func isEventChannel(channel string) bool {
+	return strings.HasSuffix(channel, EventChannelSuffix)
+}
+
+// Webhook events raised while connectionsMutex is held, published by
+// unlockConnections once it is released. Guarded by connectionsMutex
+var pendingWebhooks []webhook.Event
+
+// emitEventLocked broadcasts an event about a device on the event channel of
+// the channel it happened on and queues it for the webhooks as a
+// msgType.eventType event, e.g. geofence.enter. connectionsMutex must be held
+// and released with unlockConnections
+func emitEventLocked(channel, msgType, eventType, deviceID string, payload interface{}) {
+	env, err := NewEnvelope(msgType, deviceID, payload)
+	if err != nil {
+		log.Printf("Error building %s envelope: %v", msgType, err)
+		return
+	}
+
+	env.Channel = EventChannel(channel)
+	broadcastLocked(env)
+
+	if Webhooks == nil {
+		return
+	}
+
+	event, err := webhook.NewEvent(msgType+"."+eventType, channel, payload)
+	if err != nil {
+		log.Println("Error building webhook event:", err)
+		return
+	}
+
+	pendingWebhooks = append(pendingWebhooks, event)
+}
+
+// unlockConnections releases connectionsMutex and then hands the webhook
+// events raised while it was held to the webhooks, so slow deliveries and
+// dead-letter writes don't hold up the fan-out
+func unlockConnections() {
+	events := pendingWebhooks
+	pendingWebhooks = nil
+	connectionsMutex.Unlock()
+
+	for _, event := range events {
+		Webhooks.Publish(event)
+	}
+}
+
+// EventBacklogSize is the number of recent broadcasts kept for clients resuming
+// a stream after a reconnect
+var EventBacklogSize = 1024
+
+// Last event ID handed out, guarded by connectionsMutex
+var lastEventID uint64
+
+// Ring buffer of recent broadcasts, the event with ID n stored at n modulo its
+// length. Allocated with the first broadcast and guarded by connectionsMutex
+var eventBacklog []*Envelope
+
+// recordEvent assigns the envelope the next event ID and keeps it in the
+// backlog, connectionsMutex must be held
+func recordEvent(env *Envelope) {
+	lastEventID++
+	env.ID = lastEventID
+
+	if EventBacklogSize <= 0 {
+		return
+	}
+
+	if eventBacklog == nil {
+		eventBacklog = make([]*Envelope, EventBacklogSize)
+	}
+
+	eventBacklog[env.ID%uint64(len(eventBacklog))] = env
+}
+
+// eventsSince returns the broadcasts after the event ID, oldest first. It
+// returns false if some of them have already left the backlog, connectionsMutex
+// must be held
+func eventsSince(id uint64) ([]*Envelope, bool) {
+	if id >= lastEventID {
+		return nil, id == lastEventID
+	}
+
+	// The backlog holds the last len(eventBacklog) consecutive IDs ending at lastEventID
+	size := uint64(len(eventBacklog))
+	if lastEventID-id > size {
+		return nil, false
+	}
+
+	missed := make([]*Envelope, 0, lastEventID-id)
+	for next := id + 1; next <= lastEventID; next++ {
+		missed = append(missed, eventBacklog[next%size])
+	}
+
+	return missed, true
+}
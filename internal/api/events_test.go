@@ -0,0 +1,177 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geofence"
+	"github.com/nihankhan/locastream/internal/webhook"
+)
+
+func TestEventBacklog(t *testing.T) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	savedSize, savedBacklog, savedID := EventBacklogSize, eventBacklog, lastEventID
+	defer func() {
+		EventBacklogSize, eventBacklog, lastEventID = savedSize, savedBacklog, savedID
+	}()
+
+	EventBacklogSize, eventBacklog, lastEventID = 4, nil, 0
+
+	for i := 0; i < 10; i++ {
+		recordEvent(&Envelope{Type: TypeLocation})
+	}
+
+	tests := []struct {
+		since uint64
+		want  []uint64
+		ok    bool
+	}{
+		{since: 10, want: nil, ok: true},
+		{since: 9, want: []uint64{10}, ok: true},
+		{since: 6, want: []uint64{7, 8, 9, 10}, ok: true},
+		{since: 5, ok: false},
+		{since: 0, ok: false},
+		{since: 11, ok: false},
+	}
+
+	for _, tt := range tests {
+		missed, ok := eventsSince(tt.since)
+		if ok != tt.ok {
+			t.Errorf("eventsSince(%d) ok = %v, want %v", tt.since, ok, tt.ok)
+			continue
+		}
+
+		var got []uint64
+		for _, env := range missed {
+			got = append(got, env.ID)
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("eventsSince(%d) = %v, want %v", tt.since, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("eventsSince(%d) = %v, want %v", tt.since, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	forgetDevices(t, "hook-a", "hook-b")
+
+	received := make(chan string, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(webhook.HeaderEvent)
+	}))
+	t.Cleanup(server.Close)
+
+	hooks, err := webhook.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = hooks.Add(webhook.Subscription{URL: server.URL, Events: []string{"*"}, Channels: []string{"hooks-test"}, Secret: "whsec_test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fences, err := geofence.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fences.Add(geofence.Fence{
+		ID:      "hooks-depot",
+		Channel: "hooks-test",
+		Shape:   geofence.ShapeCircle,
+		Center:  &geofence.Coordinate{Latitude: 10, Longitude: 20},
+		Radius:  250,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedWebhooks, savedGeofences := Webhooks, Geofences
+	Webhooks, Geofences = webhook.NewDispatcher(hooks, ""), fences
+	t.Cleanup(func() {
+		Webhooks.Close()
+		Webhooks, Geofences = savedWebhooks, savedGeofences
+	})
+
+	publisher := &Client{
+		role:     RolePublisher,
+		deviceID: "hook-a",
+		channel:  "hooks-test",
+		channels: map[string]struct{}{},
+		queue:    newSendQueue(SendQueueSize, Overflow),
+	}
+
+	// Events raised under connectionsMutex only reach the webhooks if it is
+	// released with unlockConnections
+	steps := []struct {
+		name string
+		do   func()
+		want []string
+	}{
+		{
+			name: "publish",
+			do: func() {
+				AddConnection(publisher)
+				if _, err := PublishLocation("hooks-test", Location{DeviceID: "hook-a", Latitude: 10, Longitude: 20}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"device.online", "geofence.enter"},
+		},
+		{
+			name: "disconnect",
+			do:   func() { RemoveConnection(publisher) },
+			want: []string{"device.offline"},
+		},
+		{
+			name: "timeout",
+			do: func() {
+				env, err := PublishLocation("hooks-test", Location{DeviceID: "hook-b", Latitude: 11, Longitude: 20})
+				if err != nil {
+					t.Fatal(err)
+				}
+				expireDevices(env.ServerTime.Add(OfflineAfter))
+			},
+			want: []string{"device.offline", "device.online"},
+		},
+	}
+
+	for _, step := range steps {
+		step.do()
+
+		connectionsMutex.Lock()
+		pending := len(pendingWebhooks)
+		connectionsMutex.Unlock()
+
+		if pending != 0 {
+			t.Errorf("%s left %d webhook events pending", step.name, pending)
+		}
+
+		var got []string
+		for range step.want {
+			select {
+			case event := <-received:
+				got = append(got, event)
+			case <-time.After(time.Second):
+			}
+		}
+
+		// Deliveries run in parallel
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s delivered %v, want %v", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/export"
+	"github.com/valyala/fasthttp"
+)
+
+// ExportTrack serves the recorded path of the device named in the path as a
+// downloadable GPX, KML or GeoJSON file. The from and to query parameters
+// bound the time range and format selects the file type, gpx by default
+func ExportTrack(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if History == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "location history is disabled")
+		return
+	}
+
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = export.FormatGPX
+	}
+
+	if !export.Known(format) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("unknown export format %q", format))
+		return
+	}
+
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+
+	query, err := historyQuery(ctx, deviceID)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, truncated, err := queryNewestHistory(principal, query)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not read location history")
+		return
+	}
+
+	// Encode before writing anything so failures still get a proper error status
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, export.Track{DeviceID: deviceID, Points: records}); err != nil {
+		log.Println("Error exporting track:", err)
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not export track")
+		return
+	}
+
+	ctx.SetContentType(export.ContentType(format))
+	if truncated {
+		ctx.Response.Header.Set("X-Locastream-Truncated", "true")
+	}
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(deviceID, format)))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(buf.Bytes())
+}
+
+// exportFilename names an exported track after the device and the export date
+func exportFilename(deviceID, format string) string {
+	return fmt.Sprintf("%s-%s.%s", deviceID, time.Now().UTC().Format("20060102"), format)
+}
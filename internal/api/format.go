@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Wire formats of WebSocket connections
+const (
+	// FormatJSON sends JSON text frames
+	FormatJSON = "json"
+
+	// FormatMsgPack sends MessagePack binary frames with the same structure
+	FormatMsgPack = "msgpack"
+)
+
+// Subprotocols offered in Sec-WebSocket-Protocol to choose a wire format
+const (
+	ProtocolJSON    = "locastream.json"
+	ProtocolMsgPack = "locastream.msgpack"
+)
+
+// protocolFormats maps subprotocols to the wire formats they select
+var protocolFormats = map[string]string{
+	ProtocolJSON:    FormatJSON,
+	ProtocolMsgPack: FormatMsgPack,
+}
+
+// requestFormat returns the wire format chosen by the first format subprotocol
+// offered by the client, or JSON if it offered none. The chosen subprotocol
+// is selected in the handshake response
+func requestFormat(ctx *fasthttp.RequestCtx) string {
+	for _, protocol := range strings.Split(string(ctx.Request.Header.Peek("Sec-WebSocket-Protocol")), ",") {
+		protocol = strings.TrimSpace(protocol)
+
+		if format, ok := protocolFormats[protocol]; ok {
+			ctx.Response.Header.Set("Sec-WebSocket-Protocol", protocol)
+			return format
+		}
+	}
+
+	return FormatJSON
+}
+
+// frameSet holds the frames of an envelope, each wire format encoded when a
+// client first needs it and shared by every client using it
+type frameSet struct {
+	env *Envelope
+
+	// Frames encoded so far, by wire format
+	frames map[string]outbound
+}
+
+// newFrameSet returns the frames of an envelope, none of them encoded yet
+func newFrameSet(env *Envelope) *frameSet {
+	return &frameSet{env: env, frames: make(map[string]outbound, 1)}
+}
+
+// frame returns the frame in a wire format, encoding it the first time
+func (s *frameSet) frame(format string) (outbound, error) {
+	if format != FormatMsgPack {
+		format = FormatJSON
+	}
+
+	if frame, ok := s.frames[format]; ok {
+		return frame, nil
+	}
+
+	var data []byte
+	var err error
+	if format == FormatMsgPack {
+		data, err = marshalMsgpack(s.env)
+	} else {
+		data, err = json.Marshal(s.env)
+	}
+	if err != nil {
+		return outbound{}, err
+	}
+
+	frame := newFrame(s.env, data)
+	s.frames[format] = frame
+
+	return frame, nil
+}
+
+// marshalMsgpack encodes a value as MessagePack with the fields encoding/json
+// would give it. Whole numbers use the smallest integer encoding and other
+// numbers are 64-bit floats
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envelopeFields is an Envelope without its methods, so encoding it doesn't
+// call EncodeMsgpack again
+type envelopeFields Envelope
+
+// msgpackEnvelope is an envelope as encoded in MessagePack frames, with the
+// payload encoded from the value it was built from rather than its JSON
+type msgpackEnvelope struct {
+	*envelopeFields
+	Payload interface{} `json:"payload"`
+}
+
+// EncodeMsgpack encodes the envelope with its payload as a MessagePack value,
+// including the envelopes of snapshots
+func (e *Envelope) EncodeMsgpack(enc *msgpack.Encoder) error {
+	payload := e.payload
+
+	// Envelopes rebuilt from stored payloads only have the JSON
+	if payload == nil && len(e.Payload) > 0 {
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			return err
+		}
+	}
+
+	return enc.Encode(msgpackEnvelope{envelopeFields: (*envelopeFields)(e), Payload: payload})
+}
+
+// messageType returns the WebSocket message type of the client's wire format
+func (c *Client) messageType() int {
+	if c.format == FormatMsgPack {
+		return websocket.BinaryMessage
+	}
+
+	return websocket.TextMessage
+}
+
+// message is a message received from a client in its wire format
+type message struct {
+	data    []byte
+	msgpack bool
+}
+
+// errTrailing reports data after the first value of a message
+var errTrailing = errors.New("trailing data after message")
+
+// message wraps a frame received from the client. Binary frames on MessagePack
+// connections are MessagePack, anything else is taken as JSON
+func (c *Client) message(messageType int, data []byte) message {
+	return message{data: data, msgpack: c.format == FormatMsgPack && messageType == websocket.BinaryMessage}
+}
+
+// unmarshal decodes the message into v, rejecting unknown fields and anything
+// after the first value
+func (m message) unmarshal(v interface{}) error {
+	if m.msgpack {
+		r := bytes.NewReader(m.data)
+
+		dec := msgpack.NewDecoder(r)
+		dec.SetCustomStructTag("json")
+		dec.DisallowUnknownFields(true)
+
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+
+		if r.Len() > 0 {
+			return errTrailing
+		}
+
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(m.data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errTrailing
+	}
+
+	return nil
+}
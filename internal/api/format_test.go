@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// jsonForm re-encodes a decoded MessagePack value as it would appear in JSON,
+// with timestamps in UTC like the JSON frames
+func jsonForm(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+
+	var normalize func(v interface{}) interface{}
+	normalize = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case time.Time:
+			return v.UTC()
+		case map[string]interface{}:
+			for key, value := range v {
+				v[key] = normalize(value)
+			}
+		case []interface{}:
+			for i, value := range v {
+				v[i] = normalize(value)
+			}
+		}
+		return v
+	}
+
+	data, err := json.Marshal(normalize(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+func TestFrameSetMsgPack(t *testing.T) {
+	env, err := NewLocationEnvelope("fleet", Location{DeviceID: "truck-1", Latitude: 52.5, Longitude: 13.4, Timestamp: 1700000000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Motion = &Motion{}
+
+	// Envelopes rebuilt from stored payloads have no payload value
+	stored := *env
+	stored.payload = nil
+
+	snapshot, err := NewEnvelope(TypeSnapshot, "", []*Envelope{env, &stored})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, env := range map[string]*Envelope{"new": env, "stored": &stored, "snapshot": snapshot} {
+		t.Run(name, func(t *testing.T) {
+			frames := newFrameSet(env)
+
+			frame, err := frames.frame(FormatMsgPack)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the formats clients use are encoded
+			if _, ok := frames.frames[FormatJSON]; ok {
+				t.Error("JSON frame encoded for a MessagePack client")
+			}
+
+			jsonFrame, err := frames.frame(FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var doc interface{}
+			if err := msgpack.Unmarshal(frame.data, &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			var want interface{}
+			if err := json.Unmarshal(jsonFrame.data, &want); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := jsonForm(t, doc); !reflect.DeepEqual(got, want) {
+				t.Errorf("MessagePack frame %v, JSON frame %s", got, jsonFrame.data)
+			}
+
+			if frame.key != jsonFrame.key || frame.typ != env.Type {
+				t.Errorf("frame key %q type %q", frame.key, frame.typ)
+			}
+		})
+	}
+}
+
+func TestParseLocationMsgPack(t *testing.T) {
+	encode := func(doc string) message {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := marshalMsgpack(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message{data: data, msgpack: true}
+	}
+
+	now := time.UnixMilli(1700000000000)
+
+	location, verr := parseLocation(encode(`{"deviceId":"truck-1","latitude":52.5,"longitude":13,"timestamp":1700000000000}`), now)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+	if want := (Location{DeviceID: "truck-1", Latitude: 52.5, Longitude: 13, Timestamp: 1700000000000}); location != want {
+		t.Errorf("got %+v, want %+v", location, want)
+	}
+
+	tests := []struct {
+		name  string
+		msg   message
+		code  string
+		field string
+	}{
+		{name: "trailing data", msg: message{data: append(encode(`{"latitude":1,"longitude":2}`).data, 0xc0), msgpack: true}, code: ErrCodeMalformed},
+		{name: "trailing JSON", msg: message{data: []byte(`{"latitude":1,"longitude":2} {}`)}, code: ErrCodeMalformed},
+		{name: "unknown field", msg: encode(`{"latitude":1,"longitude":2,"speed":3}`), code: ErrCodeUnknownField, field: "speed"},
+		// MessagePack type errors don't name the field
+		{name: "wrong type", msg: encode(`{"latitude":"1","longitude":2}`), code: ErrCodeMalformed},
+		{name: "not a map", msg: message{data: []byte{0x92, 0x01, 0x02}, msgpack: true}, code: ErrCodeMalformed},
+		{name: "missing field", msg: encode(`{"latitude":1}`), code: ErrCodeMissingField, field: "longitude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, verr := parseLocation(tt.msg, now)
+			if verr == nil {
+				t.Fatal("parseLocation succeeded")
+			}
+
+			if verr.Code != tt.code || verr.Field != tt.field {
+				t.Errorf("got %s on %q, want %s on %q", verr.Code, verr.Field, tt.code, tt.field)
+			}
+		})
+	}
+}
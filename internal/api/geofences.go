@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/geofence"
+	"github.com/valyala/fasthttp"
+)
+
+// TypeGeofence messages carry a geofence.Event
+const TypeGeofence = "geofence"
+
+// Geofences holds the geofences updates are checked against. Geofencing is
+// disabled while it is nil
+var Geofences *geofence.Registry
+
+// evaluateGeofences checks an accepted location update against the geofences
+// of its channel and broadcasts the events it raises on the channel's event
+// channel and to the webhooks. connectionsMutex must not be held, it is only
+// taken to broadcast the events
+func evaluateGeofences(env *Envelope, location Location) {
+	if Geofences == nil {
+		return
+	}
+
+	events := Geofences.Evaluate(env.DeviceID, env.Channel, location.Latitude, location.Longitude, env.ServerTime)
+	if len(events) == 0 {
+		return
+	}
+
+	connectionsMutex.Lock()
+	defer unlockConnections()
+
+	for _, event := range events {
+		emitEventLocked(env.Channel, TypeGeofence, event.Type, event.DeviceID, event)
+	}
+}
+
+// ListGeofences serves the geofences the caller may see, optionally limited to
+// the channel named by the channel query parameter
+func ListGeofences(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !geofencesEnabled(ctx) {
+		return
+	}
+
+	channel := string(ctx.QueryArgs().Peek("channel"))
+
+	writeJSON(ctx, fasthttp.StatusOK, Geofences.List(func(f geofence.Fence) bool {
+		return principal.CanAccess(f.Channel) && (channel == "" || f.Channel == channel)
+	}))
+}
+
+// CreateGeofence adds the geofence in the request body. It takes the
+// publisher role or admin rights
+func CreateGeofence(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !geofencesEnabled(ctx) {
+		return
+	}
+
+	if !principal.CanManage() {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to create geofences")
+		return
+	}
+
+	var fence geofence.Fence
+	dec := json.NewDecoder(bytes.NewReader(ctx.PostBody()))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&fence); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, "invalid geofence: "+err.Error())
+		return
+	}
+
+	if fence.Channel == "" {
+		fence.Channel = DefaultChannel
+	}
+
+	if err := validateChannel(fence.Channel); err != nil || isEventChannel(fence.Channel) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid channel name %q", fence.Channel))
+		return
+	}
+
+	if !principal.CanAccess(fence.Channel) {
+		writeError(ctx, fasthttp.StatusForbidden, "access to channel "+fence.Channel+" denied")
+		return
+	}
+
+	if fence.ID != "" && !deviceIDPattern.MatchString(fence.ID) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid geofence ID %q", fence.ID))
+		return
+	}
+
+	if _, exists := Geofences.Get(fence.ID); exists {
+		writeError(ctx, fasthttp.StatusConflict, "geofence "+fence.ID+" already exists")
+		return
+	}
+
+	fence, err := Geofences.Add(fence)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusCreated, fence)
+}
+
+// GetGeofence serves the geofence named in the path
+func GetGeofence(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !geofencesEnabled(ctx) {
+		return
+	}
+
+	fence, ok := visibleGeofence(ctx, principal)
+	if !ok {
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, fence)
+}
+
+// DeleteGeofence removes the geofence named in the path. Like creating one, it
+// takes the publisher role or admin rights
+func DeleteGeofence(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !geofencesEnabled(ctx) {
+		return
+	}
+
+	if !principal.CanManage() {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to remove geofences")
+		return
+	}
+
+	fence, ok := visibleGeofence(ctx, principal)
+	if !ok {
+		return
+	}
+
+	if err := Geofences.Remove(fence.ID); err != nil {
+		if errors.Is(err, geofence.ErrNotFound) {
+			writeError(ctx, fasthttp.StatusNotFound, "unknown geofence "+fence.ID)
+			return
+		}
+
+		log.Println("Error removing geofence:", err)
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not remove geofence")
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// visibleGeofence looks up the geofence named in the path, replying 404 if it
+// doesn't exist or is on a channel the caller can't access
+func visibleGeofence(ctx *fasthttp.RequestCtx, principal *auth.Principal) (geofence.Fence, bool) {
+	id := fmt.Sprint(ctx.UserValue("id"))
+
+	fence, ok := Geofences.Get(id)
+	if !ok || !principal.CanAccess(fence.Channel) {
+		writeError(ctx, fasthttp.StatusNotFound, "unknown geofence "+id)
+		return geofence.Fence{}, false
+	}
+
+	return fence, true
+}
+
+// geofencesEnabled replies 503 if geofencing is disabled
+func geofencesEnabled(ctx *fasthttp.RequestCtx) bool {
+	if Geofences == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "geofencing is disabled")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/nihankhan/locastream/internal/store"
+)
+
+// History records every accepted location update. History is disabled while
+// it is nil
+var History store.Store
+
+// recordHistory hands an accepted location update to the history store
+func recordHistory(env *Envelope, location Location) {
+	if History == nil {
+		return
+	}
+
+	record := store.Record{
+		DeviceID:  env.DeviceID,
+		Channel:   env.Channel,
+		Seq:       env.Seq,
+		Time:      env.ServerTime,
+		Latitude:  location.Latitude,
+		Longitude: location.Longitude,
+		Payload:   env.Payload,
+	}
+
+	// Motion and route progress are kept so replays show what was broadcast
+	var err error
+	if env.Motion != nil {
+		if record.Motion, err = json.Marshal(env.Motion); err != nil {
+			log.Println("Error encoding motion for the location history:", err)
+		}
+	}
+	if env.Route != nil {
+		if record.Route, err = json.Marshal(env.Route); err != nil {
+			log.Println("Error encoding route progress for the location history:", err)
+		}
+	}
+
+	if err := History.Append(record); err != nil {
+		log.Println("Error recording location history:", err)
+	}
+}
+
+// recordEnvelope rebuilds the location message a stored record was broadcast as
+func recordEnvelope(r store.Record) *Envelope {
+	env := &Envelope{
+		Type:       TypeLocation,
+		Version:    MessageVersion,
+		Channel:    r.Channel,
+		DeviceID:   r.DeviceID,
+		Seq:        r.Seq,
+		ServerTime: r.Time,
+		Payload:    r.Payload,
+	}
+
+	if len(r.Motion) > 0 {
+		env.Motion = &Motion{}
+		if err := json.Unmarshal(r.Motion, env.Motion); err != nil {
+			log.Println("Error decoding recorded motion:", err)
+			env.Motion = nil
+		}
+	}
+
+	if len(r.Route) > 0 {
+		env.Route = &RouteProgress{}
+		if err := json.Unmarshal(r.Route, env.Route); err != nil {
+			log.Println("Error decoding recorded route progress:", err)
+			env.Route = nil
+		}
+	}
+
+	return env
+}
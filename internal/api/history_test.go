@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/store"
+)
+
+// memoryStore is a store.Store that keeps records in memory
+type memoryStore struct {
+	records []store.Record
+}
+
+func (m *memoryStore) Append(records ...store.Record) error {
+	m.records = append(m.records, records...)
+	return nil
+}
+
+func (m *memoryStore) Query(q store.Query) ([]store.Record, error) {
+	return m.records, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+func TestRecordHistory(t *testing.T) {
+	saved := History
+	defer func() { History = saved }()
+
+	mem := &memoryStore{}
+	History = mem
+
+	heading := 90.0
+	eta := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	location := Location{DeviceID: "hist-1", Latitude: 1, Longitude: 2}
+
+	env := &Envelope{
+		Type:       TypeLocation,
+		Version:    MessageVersion,
+		Channel:    "fleet",
+		DeviceID:   "hist-1",
+		Seq:        7,
+		ServerTime: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Motion:     &Motion{Distance: 120, Speed: 3, Heading: &heading},
+		Route:      &RouteProgress{Along: 10, Remaining: 90, ETA: &eta},
+	}
+
+	var err error
+	if env.Payload, err = json.Marshal(location); err != nil {
+		t.Fatal(err)
+	}
+
+	recordHistory(env, location)
+
+	if len(mem.records) != 1 {
+		t.Fatalf("recorded %d records", len(mem.records))
+	}
+
+	got, err := json.Marshal(recordEnvelope(mem.records[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotMap, wantMap map[string]interface{}
+	if err := json.Unmarshal(got, &gotMap); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(want, &wantMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotMap, wantMap) {
+		t.Errorf("replayed as %s, broadcast as %s", got, want)
+	}
+}
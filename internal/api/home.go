@@ -25,34 +25,267 @@ var HomeTemplate = `<!DOCTYPE html>
     <h1>Real-Time Location Streaming</h1>
     <div id="map"></div>
     <div class="info">
+        <p>Device: <span id="device"></span></p>
         <p>Duration: <span id="duration"></span></p>
         <p>Distance: <span id="distance"></span></p>
+        <p>Speed: <span id="speed"></span></p>
+        <p>Heading: <span id="heading"></span></p>
+        <p>Remaining: <span id="remaining"></span></p>
+    </div>
+    <div id="replay" class="info" style="display: none;">
+        <button id="replay-play">Pause</button>
+        <select id="replay-speed">
+            <option value="1">1x</option>
+            <option value="4">4x</option>
+            <option value="16">16x</option>
+        </select>
+        <input id="replay-seek" type="range" style="width: 50%;">
+        <span id="replay-time"></span>
     </div>
     <script src="https://unpkg.com/leaflet/dist/leaflet.js"></script>
     <script>
         var map = L.map('map').setView([0, 0], 13);
-        var markers = {}; // Object to store markers for each user
+        var markers = {}; // Object to store markers for each device
+        var track = null; // Recorded path of the selected device
+        var selected = null; // Device whose trip stats are shown, the latest to move until one is clicked
+        var clusters = L.layerGroup().addTo(map); // Groups of devices shown instead of markers when zoomed out
+        var fitted = false; // Whether the map was zoomed to the devices of the first snapshot
 
         L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
             attribution: '© <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors'
         }).addTo(map);
 
-        var ws = new WebSocket("ws://" + window.location.host + "/ws");
-        ws.onmessage = function(event) {
-            var location = JSON.parse(event.data);
-            var userId = location.userId; // Assuming each location object has a userId property
+        // Follow the channel named in the page URL, e.g. /home?channel=fleet-a
+        var params = new URLSearchParams(window.location.search);
+        var channel = params.get("channel") || "default";
+
+        // Browsers can't set headers on WebSockets, so the token rides in the protocol list
+        var token = params.get("token");
+        var protocols = token ? ["bearer", token] : [];
+        // Replay a recorded trip instead of following the live stream, e.g. /home?replay=truck-42&from=...&to=...&speed=4
+        var replay = params.get("replay");
+        var replayState = null;
+        var seeking = false;
+
+        var ws;
+        if (replay) {
+            var query = new URLSearchParams({ deviceId: replay, speed: params.get("speed") || "1" });
+            ["from", "to"].forEach(function(name) {
+                if (params.get(name)) {
+                    query.set(name, params.get(name));
+                }
+            });
+
+            ws = new WebSocket("ws://" + window.location.host + "/ws/replay?" + query.toString(), protocols);
+            document.getElementById("replay").style.display = "block";
+            document.getElementById("replay-speed").value = query.get("speed");
+        } else {
+            // A wallboard can ask for fewer updates, e.g. /home?maxRate=1
+            var subscription = new URLSearchParams({ channel: channel });
+            if (params.get("maxRate")) {
+                subscription.set("maxRate", params.get("maxRate"));
+            }
+
+            ws = new WebSocket("ws://" + window.location.host + "/ws/subscribe?" + subscription.toString(), protocols);
+        }
+
+        function sendControl(control) {
+            ws.send(JSON.stringify(control));
+        }
+
+        // Only receive the devices inside the visible part of the map, grouped
+        // into clusters by the server when zoomed out
+        map.on("moveend", function() {
+            if (replay || ws.readyState !== WebSocket.OPEN) {
+                return;
+            }
+
+            var bounds = map.getBounds();
+            sendControl({ type: "viewport", bbox: {
+                minLon: Math.max(bounds.getWest(), -180),
+                minLat: Math.max(bounds.getSouth(), -90),
+                maxLon: Math.min(bounds.getEast(), 180),
+                maxLat: Math.min(bounds.getNorth(), 90)
+            }, zoom: map.getZoom() });
+        });
+
+        document.getElementById("replay-play").onclick = function() {
+            sendControl({ type: replayState === "playing" ? "pause" : "play" });
+        };
+
+        document.getElementById("replay-speed").onchange = function() {
+            sendControl({ type: "speed", speed: Number(this.value) });
+        };
+
+        var seek = document.getElementById("replay-seek");
+        seek.oninput = function() { seeking = true; };
+        seek.onchange = function() {
+            seeking = false;
+            sendControl({ type: "seek", time: Number(this.value) });
+        };
+
+        // showReplayTime moves the seek bar to the time being replayed
+        function showReplayTime(time) {
+            if (!seeking) {
+                seek.value = time;
+            }
+            document.getElementById("replay-time").textContent = new Date(time).toLocaleString();
+        }
+
+        // showTrack draws the recorded path of a device from the location history,
+        // zooming to it when a time range is given
+        function showTrack(deviceId, from, to) {
+            var headers = token ? { "Authorization": "Bearer " + token } : {};
+            var url = "/api/devices/" + encodeURIComponent(deviceId) + "/track?format=geojson&maxPoints=500";
+            if (from) {
+                url += "&from=" + from + "&to=" + to;
+            }
+
+            fetch(url, { headers: headers })
+                .then(function(response) { return response.ok ? response.json() : null; })
+                .then(function(feature) {
+                    if (track) {
+                        map.removeLayer(track);
+                        track = null;
+                    }
+
+                    if (feature && feature.geometry) {
+                        track = L.geoJSON(feature).addTo(map);
+                        if (from) {
+                            map.fitBounds(track.getBounds());
+                        }
+                    }
+                });
+        }
 
-            // Check if a marker exists for the user, if not, create one
-            if (!markers[userId]) {
-                markers[userId] = L.marker([location.latitude, location.longitude]).addTo(map);
+        // showLocation moves the device's marker to the position in a location message
+        function showLocation(message) {
+            var location = message.payload;
+            var deviceId = message.deviceId; // Stamped by the server for every publisher
+
+            // Check if a marker exists for the device, if not, create one
+            if (!markers[deviceId]) {
+                markers[deviceId] = L.marker([location.latitude, location.longitude]).bindTooltip(deviceId).addTo(map);
+                markers[deviceId].on("click", function() {
+                    selected = deviceId;
+                    showTrack(deviceId);
+                    showMotion(deviceId, markers[deviceId].stats);
+                });
             } else {
                 // If marker exists, update its position
-                markers[userId].setLatLng([location.latitude, location.longitude]).update();
+                markers[deviceId].setLatLng([location.latitude, location.longitude]).update();
+            }
+
+            // Trip stats are computed by the server from the device's fixes
+            markers[deviceId].stats = message;
+            if (!selected || selected === deviceId) {
+                showMotion(deviceId, message);
+            }
+        }
+
+        // showMotion shows the trip stats of a device, and its progress along its planned route if it has one
+        function showMotion(deviceId, message) {
+            if (!message || !message.motion) {
+                return;
+            }
+            var motion = message.motion;
+
+            document.getElementById('device').textContent = deviceId;
+            document.getElementById('duration').textContent = (motion.elapsed / 60).toFixed(1) + " minutes";
+            document.getElementById('distance').textContent = (motion.distance / 1000).toFixed(2) + " km";
+            document.getElementById('speed').textContent = (motion.speed * 3.6).toFixed(1) + " km/h (average " + (motion.averageSpeed * 3.6).toFixed(1) + " km/h)";
+            document.getElementById('heading').textContent = motion.heading === undefined ? "" : Math.round(motion.heading) + "°";
+
+            var route = message.route;
+            var remaining = "";
+            if (route) {
+                remaining = (route.remaining / 1000).toFixed(2) + " km";
+                if (route.eta) {
+                    remaining += ", arriving " + new Date(route.eta).toLocaleTimeString();
+                }
+                if (route.offRoute) {
+                    remaining += " (off route, " + Math.round(route.crossTrack) + " m away)";
+                }
             }
+            document.getElementById('remaining').textContent = remaining;
+        }
+
+        // clearMarkers removes every device marker and cluster from the map
+        function clearMarkers() {
+            Object.keys(markers).forEach(function(id) {
+                map.removeLayer(markers[id]);
+            });
+            markers = {};
+            clusters.clearLayers();
+        }
+
+        // showClusters replaces the markers with the clusters in a clusters message
+        function showClusters(set) {
+            clearMarkers();
+
+            set.clusters.forEach(function(cluster) {
+                var circle = L.circleMarker([cluster.latitude, cluster.longitude], { radius: 8 + 4 * Math.log10(cluster.count) })
+                    .bindTooltip(cluster.deviceId || cluster.count + " devices")
+                    .addTo(clusters);
+                circle.on("click", function() {
+                    map.setView([cluster.latitude, cluster.longitude], Math.min(set.zoom + 2, map.getMaxZoom()));
+                });
+            });
+        }
+
+        ws.onmessage = function(event) {
+            var message = JSON.parse(event.data);
+
+            switch (message.type) {
+            case "snapshot":
+                // Latest known positions, sent before the live stream starts and
+                // when zooming in far enough to leave the clusters
+                clearMarkers();
+                message.payload.forEach(showLocation);
+
+                var deviceIds = Object.keys(markers);
+                if (!fitted && deviceIds.length > 0) {
+                    map.fitBounds(L.featureGroup(deviceIds.map(function(id) { return markers[id]; })).getBounds(), { maxZoom: 13 });
+                }
+                if (!fitted) {
+                    fitted = true;
+                    map.fire("moveend");
+                }
+                break;
+            case "clusters":
+                showClusters(message.payload);
+                break;
+            case "viewport":
+                // Devices leaving the visible part of the map are no longer updated
+                var change = message.payload;
+                if (change.type === "leave" && markers[change.deviceId]) {
+                    map.removeLayer(markers[change.deviceId]);
+                    delete markers[change.deviceId];
+                }
+                break;
+            case "location":
+                showLocation(message);
+                if (replay) {
+                    showReplayTime(Date.parse(message.serverTime));
+                }
+                break;
+            case "replay":
+                // State of the replay session: playing, paused or ended
+                var status = message.payload;
+                replayState = status.state;
 
-            // Update duration and distance
-            document.getElementById('duration').textContent = location.duration + " minutes";
-            document.getElementById('distance').textContent = location.distance + " km";
+                if (seek.max != status.to) {
+                    seek.min = status.from;
+                    seek.max = status.to;
+                    showTrack(replay, status.from, status.to);
+                }
+                if (status.time) {
+                    showReplayTime(status.time);
+                }
+
+                document.getElementById("replay-play").textContent = status.state === "playing" ? "Pause" : "Play";
+                break;
+            }
         };
     </script>
 </body>
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// MaxBatchSize is the largest number of updates accepted in one request
+const MaxBatchSize = 1000
+
+// IngestResult reports what happened to one update of an ingestion request
+type IngestResult struct {
+	Index    int              `json:"index"`
+	DeviceID string           `json:"deviceId,omitempty"`
+	Seq      uint64           `json:"seq,omitempty"`
+	Error    *ValidationError `json:"error,omitempty"`
+}
+
+// IngestResponse is the body of the reply to an ingestion request
+type IngestResponse struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []IngestResult `json:"results"`
+}
+
+// IngestLocations accepts a single location update or a JSON array of them
+// over plain HTTP, for devices that can't hold a WebSocket open. Updates go
+// through the same validation and broadcast pipeline as WebSocket publishers
+func IngestLocations(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if !principal.Publish {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to publish")
+		return
+	}
+
+	channel := string(ctx.QueryArgs().Peek("channel"))
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	if err := validateChannel(channel); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !principal.CanAccess(channel) {
+		writeError(ctx, fasthttp.StatusForbidden, "access to channel "+channel+" denied")
+		return
+	}
+
+	if isEventChannel(channel) {
+		writeError(ctx, fasthttp.StatusBadRequest, "cannot publish into event channel "+channel)
+		return
+	}
+
+	// Updates without a deviceId field are published as this device
+	defaultDevice := string(ctx.QueryArgs().Peek("deviceId"))
+	if defaultDevice == "" {
+		defaultDevice = string(ctx.Request.Header.Peek("X-Device-ID"))
+	}
+	if defaultDevice == "" {
+		defaultDevice = principal.DeviceID
+	}
+
+	items, err := splitBatch(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := IngestResponse{Results: make([]IngestResult, 0, len(items))}
+	now := time.Now()
+
+	for i, item := range items {
+		result := ingest(principal, channel, defaultDevice, item, now)
+		result.Index = i
+
+		if result.Error != nil {
+			resp.Rejected++
+		} else {
+			resp.Accepted++
+		}
+
+		resp.Results = append(resp.Results, result)
+	}
+
+	status := fasthttp.StatusAccepted
+	if resp.Accepted == 0 {
+		status = fasthttp.StatusBadRequest
+	}
+
+	writeJSON(ctx, status, resp)
+}
+
+// ingest validates and publishes one update of an ingestion request
+func ingest(principal *auth.Principal, channel, defaultDevice string, msg []byte, now time.Time) IngestResult {
+	location, verr := ParseLocation(msg, now)
+	if verr != nil {
+		return IngestResult{Error: verr}
+	}
+
+	if location.DeviceID == "" {
+		location.DeviceID = defaultDevice
+	}
+
+	if location.DeviceID == "" {
+		return IngestResult{Error: &ValidationError{Code: ErrCodeMissingField, Field: "deviceId", Message: "deviceId is required"}}
+	}
+
+	if !deviceIDPattern.MatchString(location.DeviceID) {
+		return IngestResult{Error: &ValidationError{Code: ErrCodeMalformed, Field: "deviceId", Message: fmt.Sprintf("invalid device ID %q", location.DeviceID)}}
+	}
+
+	// Reject updates for devices the caller may not publish as
+	if !principal.CanPublishAs(location.DeviceID) {
+		log.Printf("Rejected location from %s claiming device ID %s", principal.Subject, location.DeviceID)
+		return IngestResult{
+			DeviceID: location.DeviceID,
+			Error:    &ValidationError{Code: ErrCodeDeviceMismatch, Field: "deviceId", Message: "not allowed to publish as " + location.DeviceID},
+		}
+	}
+
+	env, err := PublishLocation(channel, location)
+	if err != nil {
+		log.Println("Error publishing location:", err)
+		return IngestResult{DeviceID: location.DeviceID, Error: &ValidationError{Code: ErrCodeInternal, Message: "could not publish location"}}
+	}
+
+	return IngestResult{DeviceID: location.DeviceID, Seq: env.Seq}
+}
+
+// splitBatch splits a request body holding one JSON object or an array of them
+// into the raw updates
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 {
+		return nil, fmt.Errorf("request body is empty")
+	}
+
+	if body[0] != '[' {
+		return []json.RawMessage{body}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("malformed batch: %v", err)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("batch is empty")
+	}
+
+	if len(items) > MaxBatchSize {
+		return nil, fmt.Errorf("batch holds %d updates, the limit is %d", len(items), MaxBatchSize)
+	}
+
+	return items, nil
+}
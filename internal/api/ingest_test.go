@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestIngestLocations(t *testing.T) {
+	forgetDevices(t, "ing-a", "ing-b")
+
+	// result is the outcome expected for one update, by device or error code
+	type result struct {
+		deviceID string
+		code     string
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		body   string
+		status int
+		want   []result
+	}{
+		{
+			name:   "single object",
+			query:  "deviceId=ing-a",
+			body:   `{"latitude":23.8,"longitude":90.4}`,
+			status: fasthttp.StatusAccepted,
+			want:   []result{{deviceID: "ing-a"}},
+		},
+		{
+			name:   "array",
+			query:  "deviceId=ing-a",
+			body:   `[{"latitude":23.8,"longitude":90.4},{"deviceId":"ing-b","latitude":23.9,"longitude":90.5}]`,
+			status: fasthttp.StatusAccepted,
+			want:   []result{{deviceID: "ing-a"}, {deviceID: "ing-b"}},
+		},
+		{
+			name:  "mixed batch",
+			query: "deviceId=ing-a",
+			body: `[{"latitude":91,"longitude":90.4},{"latitude":23.8,"longitude":90.4},` +
+				`{"latitude":23.8,"longitude":90.4,"speed":3},{"deviceId":"bad id","latitude":1,"longitude":1}]`,
+			status: fasthttp.StatusAccepted,
+			want: []result{
+				{code: ErrCodeOutOfRange},
+				{deviceID: "ing-a"},
+				{code: ErrCodeUnknownField},
+				{code: ErrCodeMalformed},
+			},
+		},
+		{
+			name:   "nothing accepted",
+			body:   `[{"latitude":23.8,"longitude":90.4},{"latitude":"x","longitude":90.4}]`,
+			status: fasthttp.StatusBadRequest,
+			want:   []result{{code: ErrCodeMissingField}, {code: ErrCodeMalformed}},
+		},
+		{name: "empty body", status: fasthttp.StatusBadRequest},
+		{name: "empty batch", body: `[]`, status: fasthttp.StatusBadRequest},
+		{name: "malformed batch", body: `[{"latitude":1,`, status: fasthttp.StatusBadRequest},
+		{
+			name:   "batch over the limit",
+			query:  "deviceId=ing-a",
+			body:   "[" + strings.Repeat(`{"latitude":1,"longitude":1},`, MaxBatchSize) + `{"latitude":1,"longitude":1}]`,
+			status: fasthttp.StatusBadRequest,
+		},
+		{name: "event channel", query: "channel=ingest-test:events&deviceId=ing-a", body: `{"latitude":1,"longitude":1}`, status: fasthttp.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			if !strings.Contains(query, "channel=") {
+				query += "&channel=ingest-test"
+			}
+
+			var ctx fasthttp.RequestCtx
+			ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+			ctx.Request.SetRequestURI("/api/locations?" + query)
+			ctx.Request.SetBodyString(tt.body)
+
+			IngestLocations(&ctx)
+
+			if status := ctx.Response.StatusCode(); status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, ctx.Response.Body())
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			var resp IngestResponse
+			if err := json.Unmarshal(ctx.Response.Body(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d", len(resp.Results), len(tt.want))
+			}
+
+			accepted := 0
+			for i, want := range tt.want {
+				got := resp.Results[i]
+				if got.Index != i {
+					t.Errorf("result %d has index %d", i, got.Index)
+				}
+
+				if want.code == "" {
+					accepted++
+					if got.Error != nil || got.DeviceID != want.deviceID || got.Seq == 0 {
+						t.Errorf("result %d = %+v, want an update from %s", i, got, want.deviceID)
+					}
+					continue
+				}
+
+				if got.Error == nil || got.Error.Code != want.code {
+					t.Errorf("result %d = %+v, want error %s", i, got, want.code)
+				}
+			}
+
+			if resp.Accepted != accepted || resp.Rejected != len(tt.want)-accepted {
+				t.Errorf("accepted %d, rejected %d, want %d and %d", resp.Accepted, resp.Rejected, accepted, len(tt.want)-accepted)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"time"
+)
+
+// TypeDevice messages report devices coming online and going offline
+const TypeDevice = "device"
+
+// Device lifecycle event types
+const (
+	DeviceOnline  = "online"
+	DeviceOffline = "offline"
+)
+
+// Reasons a device went offline
+const (
+	OfflineDisconnected = "disconnected"
+	OfflineTimeout      = "timeout"
+)
+
+// OfflineAfter is how long a device can go without an update before it is
+// considered offline
+var OfflineAfter = 2 * time.Minute
+
+// DeviceEvent is the payload of device messages
+type DeviceEvent struct {
+	Type     string    `json:"type"`
+	DeviceID string    `json:"deviceId"`
+	Channel  string    `json:"channel"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// onlineDevice is a device that has been sending updates
+type onlineDevice struct {
+	channel  string
+	lastSeen time.Time
+}
+
+// Map of device ID to the devices currently online, guarded by connectionsMutex
+var onlineDevices = make(map[string]*onlineDevice)
+
+// markOnlineLocked notes an accepted update, raising an online event if the
+// device was offline, connectionsMutex must be held
+func markOnlineLocked(env *Envelope) {
+	device, ok := onlineDevices[env.DeviceID]
+	if ok && device.channel == env.Channel {
+		device.lastSeen = env.ServerTime
+		return
+	}
+
+	// A device moving to another channel goes offline on the old one
+	if ok {
+		markOfflineLocked(env.DeviceID, OfflineDisconnected, env.ServerTime)
+	}
+
+	onlineDevices[env.DeviceID] = &onlineDevice{channel: env.Channel, lastSeen: env.ServerTime}
+
+	emitEventLocked(env.Channel, TypeDevice, DeviceOnline, env.DeviceID, DeviceEvent{
+		Type:     DeviceOnline,
+		DeviceID: env.DeviceID,
+		Channel:  env.Channel,
+		Time:     env.ServerTime,
+	})
+}
+
+// markOfflineLocked raises an offline event for a device that is online,
+// connectionsMutex must be held
+func markOfflineLocked(deviceID, reason string, at time.Time) {
+	device, ok := onlineDevices[deviceID]
+	if !ok {
+		return
+	}
+
+	delete(onlineDevices, deviceID)
+	resetMotionLocked(deviceID)
+
+	emitEventLocked(device.channel, TypeDevice, DeviceOffline, deviceID, DeviceEvent{
+		Type:     DeviceOffline,
+		DeviceID: deviceID,
+		Channel:  device.channel,
+		Time:     at,
+		Reason:   reason,
+	})
+}
+
+// publishingLocked reports whether a WebSocket publisher is connected as the
+// device, connectionsMutex must be held
+func publishingLocked(deviceID string) bool {
+	for _, c := range connections {
+		if c.role == RolePublisher && c.deviceID == deviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MonitorDevices marks devices that stopped sending updates offline, and
+// forgets their positions after PositionTTL, until done is closed
+func MonitorDevices(done <-chan struct{}) {
+	ticker := time.NewTicker(OfflineAfter / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			expireDevices(now.UTC())
+		}
+	}
+}
+
+// expireDevices marks devices without an update for OfflineAfter offline and
+// drops positions older than PositionTTL
+func expireDevices(now time.Time) {
+	connectionsMutex.Lock()
+	defer unlockConnections()
+
+	for id, device := range onlineDevices {
+		if now.Sub(device.lastSeen) >= OfflineAfter {
+			markOfflineLocked(id, OfflineTimeout, now)
+		}
+	}
+
+	forgetPositionsLocked(now)
+}
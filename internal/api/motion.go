@@ -0,0 +1,100 @@
+package api
+
+import (
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// MinHeadingDistance is how far in meters a device must move between two
+// fixes for the heading to be updated, so GPS jitter while standing still
+// doesn't spin it around
+const MinHeadingDistance = 2.0
+
+// Motion is the progress of a device since it came online, computed by the
+// server from successive fixes
+type Motion struct {
+	StartedAt time.Time `json:"startedAt"`
+
+	// Distance travelled in meters, summed between successive fixes
+	Distance float64 `json:"distance"`
+
+	// Elapsed seconds since the first fix
+	Elapsed float64 `json:"elapsed"`
+
+	// Speed between the last two fixes and AverageSpeed over the whole trip,
+	// in meters per second
+	Speed        float64 `json:"speed"`
+	AverageSpeed float64 `json:"averageSpeed"`
+
+	// Heading in degrees clockwise from north, unset until the device has moved
+	Heading *float64 `json:"heading,omitempty"`
+}
+
+// motionState is the last fix of a device and its motion so far
+type motionState struct {
+	latitude  float64
+	longitude float64
+	at        time.Time
+	motion    Motion
+}
+
+// Map of device ID to its motion, guarded by connectionsMutex
+var motions = make(map[string]*motionState)
+
+// updateMotionLocked adds an accepted update to its device's motion and
+// returns a copy of it, connectionsMutex must be held
+func updateMotionLocked(env *Envelope, location Location) *Motion {
+	at := fixTime(env, location)
+
+	state, ok := motions[env.DeviceID]
+	if !ok {
+		state = &motionState{motion: Motion{StartedAt: at}}
+		motions[env.DeviceID] = state
+	} else if at.Before(state.at) {
+		// Late fixes are broadcast but can't be placed on the path any more
+		motion := state.motion
+		return &motion
+	} else {
+		distance := geo.Distance(state.latitude, state.longitude, location.Latitude, location.Longitude)
+		state.motion.Distance += distance
+
+		if dt := at.Sub(state.at).Seconds(); dt > 0 {
+			state.motion.Speed = distance / dt
+		}
+
+		if distance >= MinHeadingDistance {
+			heading := geo.Bearing(state.latitude, state.longitude, location.Latitude, location.Longitude)
+			state.motion.Heading = &heading
+		}
+	}
+
+	state.latitude = location.Latitude
+	state.longitude = location.Longitude
+	state.at = at
+
+	state.motion.Elapsed = at.Sub(state.motion.StartedAt).Seconds()
+	if state.motion.Elapsed > 0 {
+		state.motion.AverageSpeed = state.motion.Distance / state.motion.Elapsed
+	}
+
+	motion := state.motion
+
+	return &motion
+}
+
+// fixTime returns when a location was recorded: the publisher's timestamp
+// when it sent one, or the server receive time
+func fixTime(env *Envelope, location Location) time.Time {
+	if location.Timestamp > 0 {
+		return time.UnixMilli(location.Timestamp).UTC()
+	}
+
+	return env.ServerTime
+}
+
+// resetMotionLocked forgets a device's motion, so it starts over the next
+// time the device comes online, connectionsMutex must be held
+func resetMotionLocked(deviceID string) {
+	delete(motions, deviceID)
+}
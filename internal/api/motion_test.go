@@ -0,0 +1,191 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+func TestUpdateMotion(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Meters between fixes 0.001 degrees apart on the equator
+	step := geo.Distance(0, 0, 0, 0.001)
+
+	type fix struct {
+		at       time.Duration
+		lat, lon float64
+	}
+
+	tests := []struct {
+		name  string
+		fixes []fix
+
+		distance, elapsed, speed, average float64
+
+		// Heading in degrees, negative for none
+		heading float64
+	}{
+		{
+			name:    "first fix",
+			fixes:   []fix{{at: 0, lat: 0, lon: 0}},
+			heading: -1,
+		},
+		{
+			name: "cumulative distance",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0, lon: 0.001},
+				{at: 20 * time.Second, lat: 0, lon: 0},
+				{at: 30 * time.Second, lat: 0, lon: 0.001},
+			},
+			distance: 3 * step,
+			elapsed:  30,
+			speed:    step / 10,
+			average:  3 * step / 30,
+			heading:  90,
+		},
+		{
+			name: "instantaneous and average speed",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0, lon: 0.001},
+				{at: time.Minute, lat: 0, lon: 0.002},
+			},
+			distance: 2 * step,
+			elapsed:  60,
+			speed:    step / 50,
+			average:  2 * step / 60,
+			heading:  90,
+		},
+		{
+			name: "heading west of north",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0.001, lon: -0.00001},
+			},
+			distance: geo.Distance(0, 0, 0.001, -0.00001),
+			elapsed:  10,
+			speed:    geo.Distance(0, 0, 0.001, -0.00001) / 10,
+			average:  geo.Distance(0, 0, 0.001, -0.00001) / 10,
+			heading:  geo.Bearing(0, 0, 0.001, -0.00001),
+		},
+		{
+			name: "heading across north",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0.001, lon: -0.00001},
+				{at: 20 * time.Second, lat: 0.002, lon: 0},
+			},
+			distance: geo.Distance(0, 0, 0.001, -0.00001) + geo.Distance(0.001, -0.00001, 0.002, 0),
+			elapsed:  20,
+			speed:    geo.Distance(0.001, -0.00001, 0.002, 0) / 10,
+			average:  (geo.Distance(0, 0, 0.001, -0.00001) + geo.Distance(0.001, -0.00001, 0.002, 0)) / 20,
+			heading:  geo.Bearing(0.001, -0.00001, 0.002, 0),
+		},
+		{
+			name: "zero time delta",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 0, lat: 0, lon: 0.001},
+			},
+			distance: step,
+			heading:  90,
+		},
+		{
+			name: "zero time delta keeps the last speed",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0, lon: 0.001},
+				{at: 10 * time.Second, lat: 0, lon: 0.002},
+			},
+			distance: 2 * step,
+			elapsed:  10,
+			speed:    step / 10,
+			average:  2 * step / 10,
+			heading:  90,
+		},
+		{
+			name: "jitter keeps the heading",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0, lon: 0.001},
+				{at: 20 * time.Second, lat: 0.00001, lon: 0.001},
+			},
+			distance: step + geo.Distance(0, 0.001, 0.00001, 0.001),
+			elapsed:  20,
+			speed:    geo.Distance(0, 0.001, 0.00001, 0.001) / 10,
+			average:  (step + geo.Distance(0, 0.001, 0.00001, 0.001)) / 20,
+			heading:  90,
+		},
+		{
+			name: "late fix",
+			fixes: []fix{
+				{at: 0, lat: 0, lon: 0},
+				{at: 10 * time.Second, lat: 0, lon: 0.001},
+				{at: 5 * time.Second, lat: 1, lon: 1},
+			},
+			distance: step,
+			elapsed:  10,
+			speed:    step / 10,
+			average:  step / 10,
+			heading:  90,
+		},
+	}
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-6
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceID := "motion-" + tt.name
+			forgetDevices(t, deviceID)
+
+			connectionsMutex.Lock()
+			defer connectionsMutex.Unlock()
+
+			var motion *Motion
+			for _, f := range tt.fixes {
+				at := start.Add(f.at)
+				env := &Envelope{DeviceID: deviceID, ServerTime: at}
+				motion = updateMotionLocked(env, Location{DeviceID: deviceID, Latitude: f.lat, Longitude: f.lon, Timestamp: at.UnixMilli()})
+			}
+
+			if !motion.StartedAt.Equal(start) {
+				t.Errorf("started at %v, want %v", motion.StartedAt, start)
+			}
+			if !near(motion.Distance, tt.distance) {
+				t.Errorf("distance %v, want %v", motion.Distance, tt.distance)
+			}
+			if !near(motion.Elapsed, tt.elapsed) {
+				t.Errorf("elapsed %v, want %v", motion.Elapsed, tt.elapsed)
+			}
+			if !near(motion.Speed, tt.speed) {
+				t.Errorf("speed %v, want %v", motion.Speed, tt.speed)
+			}
+			if !near(motion.AverageSpeed, tt.average) {
+				t.Errorf("average speed %v, want %v", motion.AverageSpeed, tt.average)
+			}
+
+			switch {
+			case tt.heading < 0 && motion.Heading != nil:
+				t.Errorf("heading %v, want none", *motion.Heading)
+			case tt.heading >= 0 && motion.Heading == nil:
+				t.Errorf("no heading, want %v", tt.heading)
+			case tt.heading >= 0 && (!near(*motion.Heading, tt.heading) || *motion.Heading < 0 || *motion.Heading >= 360):
+				t.Errorf("heading %v, want %v", *motion.Heading, tt.heading)
+			}
+		})
+	}
+
+	// Bearings just either side of north, as the cases above rely on
+	if h := geo.Bearing(0, 0, 0.001, -0.00001); h < 359 || h >= 360 {
+		t.Errorf("bearing west of north %v", h)
+	}
+	if h := geo.Bearing(0.001, -0.00001, 0.002, 0); h <= 0 || h > 1 {
+		t.Errorf("bearing east of north %v", h)
+	}
+}
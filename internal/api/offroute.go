@@ -0,0 +1,87 @@
+package api
+
+import (
+	"time"
+
+	"github.com/nihankhan/locastream/internal/route"
+)
+
+// TypeRoute messages report devices leaving and rejoining their planned route
+const TypeRoute = "route"
+
+// Route event types
+const (
+	RouteOff  = "off-route"
+	RouteBack = "back-on-route"
+)
+
+// Defaults for routes that don't set their own off-route thresholds
+var (
+	// OffRouteDistance is the cross-track distance in meters beyond which a fix is off the route
+	OffRouteDistance = 100.0
+
+	// OffRouteFixes is how many fixes in a row it takes to go off or come back on the route
+	OffRouteFixes = 3
+)
+
+// RouteEvent is the payload of route messages
+type RouteEvent struct {
+	Type       string    `json:"type"`
+	DeviceID   string    `json:"deviceId"`
+	Channel    string    `json:"channel"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	CrossTrack float64   `json:"crossTrack"`
+	Along      float64   `json:"along"`
+	Time       time.Time `json:"time"`
+}
+
+// offRouteThresholds returns the route's off-route distance and fix count,
+// falling back to the defaults
+func offRouteThresholds(r *route.Route) (distance float64, fixes int) {
+	distance, fixes = r.OffRouteDistance, r.OffRouteFixes
+	if distance == 0 {
+		distance = OffRouteDistance
+	}
+	if fixes == 0 {
+		fixes = OffRouteFixes
+	}
+
+	return distance, fixes
+}
+
+// checkDeviationLocked counts the fixes that disagree with whether the device
+// is on its route and raises an event once enough of them came in a row,
+// connectionsMutex must be held
+func checkDeviationLocked(tracker *routeTracker, env *Envelope, location Location, progress *RouteProgress, at time.Time) {
+	threshold, fixes := offRouteThresholds(tracker.route)
+
+	if (progress.CrossTrack > threshold) == tracker.offRoute {
+		tracker.streak = 0
+	} else {
+		tracker.streak++
+	}
+
+	if tracker.streak >= fixes {
+		tracker.offRoute = !tracker.offRoute
+		tracker.streak = 0
+
+		event := RouteEvent{
+			Type:       RouteBack,
+			DeviceID:   env.DeviceID,
+			Channel:    env.Channel,
+			Latitude:   location.Latitude,
+			Longitude:  location.Longitude,
+			CrossTrack: progress.CrossTrack,
+			Along:      progress.Along,
+			Time:       at,
+		}
+		if tracker.offRoute {
+			event.Type = RouteOff
+		}
+
+		emitEventLocked(env.Channel, TypeRoute, event.Type, env.DeviceID, event)
+	}
+
+	progress.OffRoute = tracker.offRoute
+}
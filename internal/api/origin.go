@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Special entries of an origin policy
+const (
+	// OriginAny allows every origin
+	OriginAny = "*"
+
+	// OriginSame allows pages served from the same host as the server
+	OriginSame = "same-origin"
+)
+
+// OriginPolicy decides which browser origins may open WebSocket connections.
+// Requests without an Origin header don't come from browsers and are allowed
+type OriginPolicy struct {
+	any        bool
+	sameOrigin bool
+
+	// Exact origins, as scheme://host[:port]
+	exact map[string]bool
+
+	// Wildcard subdomain origins
+	wildcards []wildcardOrigin
+}
+
+// wildcardOrigin matches every subdomain of a host, e.g. https://*.example.com
+type wildcardOrigin struct {
+	scheme string
+
+	// Host suffix including the leading dot, e.g. .example.com
+	suffix string
+}
+
+// Origins is the origin policy applied to WebSocket upgrades
+var Origins = &OriginPolicy{sameOrigin: true}
+
+// ParseOriginPolicy builds an origin policy from a list of entries. Each entry
+// is "*", "same-origin", an exact origin like https://app.example.com or a
+// wildcard subdomain origin like https://*.example.com
+func ParseOriginPolicy(entries []string) (*OriginPolicy, error) {
+	policy := &OriginPolicy{exact: make(map[string]bool)}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		switch entry {
+		case "":
+			continue
+		case OriginAny:
+			policy.any = true
+			continue
+		case OriginSame:
+			policy.sameOrigin = true
+			continue
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q", entry)
+		}
+
+		scheme := strings.ToLower(u.Scheme)
+		host := strings.ToLower(u.Host)
+
+		if strings.HasPrefix(host, "*.") {
+			policy.wildcards = append(policy.wildcards, wildcardOrigin{scheme: scheme, suffix: host[1:]})
+			continue
+		}
+
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid origin %q, wildcards are only allowed as the first label", entry)
+		}
+
+		policy.exact[scheme+"://"+host] = true
+	}
+
+	return policy, nil
+}
+
+// Allowed reports whether a page from the origin may connect to a server
+// reached through the host
+func (p *OriginPolicy) Allowed(origin, host string) bool {
+	if origin == "" || p.any {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	originHost := strings.ToLower(u.Host)
+
+	if p.sameOrigin && strings.EqualFold(originHost, host) {
+		return true
+	}
+
+	if p.exact[scheme+"://"+originHost] {
+		return true
+	}
+
+	for _, w := range p.wildcards {
+		if scheme == w.scheme && strings.HasSuffix(originHost, w.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// originAllowed reports whether the request's origin passes the origin policy
+func originAllowed(ctx *fasthttp.RequestCtx) bool {
+	return Origins.Allowed(string(ctx.Request.Header.Peek("Origin")), string(ctx.Host()))
+}
+
+// checkOrigin rejects requests from origins the policy doesn't allow with a 403
+func checkOrigin(ctx *fasthttp.RequestCtx) bool {
+	if originAllowed(ctx) {
+		return true
+	}
+
+	log.Printf("Rejected WebSocket upgrade from %s: origin %q not allowed", ctx.RemoteAddr(), ctx.Request.Header.Peek("Origin"))
+	writeError(ctx, fasthttp.StatusForbidden, "origin not allowed")
+
+	return false
+}
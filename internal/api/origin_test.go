@@ -0,0 +1,48 @@
+package api
+
+import "testing"
+
+func TestOriginPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		origin  string
+		host    string
+		want    bool
+	}{
+		{name: "no origin header", entries: []string{"https://app.example.com"}, origin: "", host: "localhost:8080", want: true},
+		{name: "any", entries: []string{"*"}, origin: "https://evil.example.net", host: "localhost:8080", want: true},
+		{name: "same origin", entries: []string{"same-origin"}, origin: "http://localhost:8080", host: "localhost:8080", want: true},
+		{name: "same origin host case", entries: []string{"same-origin"}, origin: "http://LOCALHOST:8080", host: "localhost:8080", want: true},
+		{name: "same origin other port", entries: []string{"same-origin"}, origin: "http://localhost:3000", host: "localhost:8080", want: false},
+		{name: "exact", entries: []string{"https://ops.example.com"}, origin: "https://ops.example.com", host: "api.example.com", want: true},
+		{name: "exact other scheme", entries: []string{"https://ops.example.com"}, origin: "http://ops.example.com", host: "api.example.com", want: false},
+		{name: "exact other host", entries: []string{"https://ops.example.com"}, origin: "https://ops.example.net", host: "api.example.com", want: false},
+		{name: "wildcard subdomain", entries: []string{"https://*.example.com"}, origin: "https://a.b.example.com", host: "api.example.com", want: true},
+		{name: "wildcard excludes apex", entries: []string{"https://*.example.com"}, origin: "https://example.com", host: "api.example.com", want: false},
+		{name: "wildcard suffix lookalike", entries: []string{"https://*.example.com"}, origin: "https://evilexample.com", host: "api.example.com", want: false},
+		{name: "wildcard other scheme", entries: []string{"https://*.example.com"}, origin: "http://a.example.com", host: "api.example.com", want: false},
+		{name: "malformed origin", entries: []string{"https://*.example.com"}, origin: "null", host: "api.example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseOriginPolicy(tt.entries)
+			if err != nil {
+				t.Fatalf("ParseOriginPolicy: %v", err)
+			}
+
+			if got := policy.Allowed(tt.origin, tt.host); got != tt.want {
+				t.Errorf("Allowed(%q, %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOriginPolicyInvalid(t *testing.T) {
+	for _, entry := range []string{"example.com", "https://", "https://a.*.example.com", "https://example.com/path"} {
+		if _, err := ParseOriginPolicy([]string{entry}); err == nil {
+			t.Errorf("ParseOriginPolicy accepted %q", entry)
+		}
+	}
+}
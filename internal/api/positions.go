@@ -0,0 +1,265 @@
+package api
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// DevicePosition is the latest accepted location of a device
+type DevicePosition struct {
+	DeviceID   string         `json:"deviceId"`
+	Channel    string         `json:"channel"`
+	Seq        uint64         `json:"seq"`
+	ServerTime time.Time      `json:"serverTime"`
+	Location   Location       `json:"location"`
+	Motion     *Motion        `json:"motion,omitempty"`
+	Route      *RouteProgress `json:"route,omitempty"`
+}
+
+// latestPosition holds a device's latest position along with the envelope it
+// was broadcast in
+type latestPosition struct {
+	position DevicePosition
+	envelope *Envelope
+}
+
+// Define a mutex to safely access the latest positions. When both are needed it
+// is taken after connectionsMutex
+var positionsMutex sync.Mutex
+
+// Map of device ID to its latest accepted location
+var latestPositions = make(map[string]*latestPosition)
+
+// PositionTTL is how long the latest position of a device is kept after its
+// last update. Older positions are dropped from snapshots, listings, viewports
+// and clusters
+var PositionTTL = time.Hour
+
+// indexCellSize is the size in degrees of the cells of the position index
+const indexCellSize = 0.25
+
+// Spatial index of the latest positions by device ID, guarded by positionsMutex
+var positionIndex = geo.NewGrid(indexCellSize)
+
+// PublishLocation accepts a validated location update posted into a channel: it
+// is wrapped in an envelope with the device's motion and route progress,
+// remembered as the device's latest position, broadcast to the channel's
+// members, recorded in the history and checked against the channel's
+// geofences. Its device is marked online
+func PublishLocation(channel string, location Location) (*Envelope, error) {
+	env, err := fanOutLocation(channel, location)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fences are checked after connectionsMutex is released so other updates
+	// are not held up by them
+	evaluateGeofences(env, location)
+
+	return env, nil
+}
+
+// fanOutLocation sequences, remembers, broadcasts and records a location update
+func fanOutLocation(channel string, location Location) (*Envelope, error) {
+	// Holding the lock from sequencing to fan-out keeps every device's updates in order
+	connectionsMutex.Lock()
+	defer unlockConnections()
+
+	env, err := NewLocationEnvelope(channel, location)
+	if err != nil {
+		return nil, err
+	}
+
+	markOnlineLocked(env)
+	env.Motion = updateMotionLocked(env, location)
+	env.Route = updateRouteLocked(env, location)
+
+	recordPosition(env, location)
+	broadcastLocked(env)
+	recordHistory(env, location)
+
+	return env, nil
+}
+
+// recordPosition remembers a location as the latest position of its device
+func recordPosition(env *Envelope, location Location) {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	latestPositions[env.DeviceID] = &latestPosition{
+		position: DevicePosition{
+			DeviceID:   env.DeviceID,
+			Channel:    env.Channel,
+			Seq:        env.Seq,
+			ServerTime: env.ServerTime,
+			Location:   location,
+			Motion:     env.Motion,
+			Route:      env.Route,
+		},
+		envelope: env,
+	}
+
+	positionIndex.Set(env.DeviceID, location.Latitude, location.Longitude)
+}
+
+// forgetPositionsLocked drops the latest positions older than PositionTTL,
+// along with the sequence numbers of their devices, and tells clients that had
+// their devices in view that they left.
+// connectionsMutex must be held
+func forgetPositionsLocked(now time.Time) {
+	var forgotten []DevicePosition
+
+	positionsMutex.Lock()
+	for id, latest := range latestPositions {
+		if now.Sub(latest.position.ServerTime) >= PositionTTL {
+			delete(latestPositions, id)
+			positionIndex.Remove(id)
+			forgotten = append(forgotten, latest.position)
+		}
+	}
+	positionsMutex.Unlock()
+
+	for _, position := range forgotten {
+		forgetSequence(position.DeviceID)
+
+		for client := range channels[position.Channel] {
+			if _, ok := client.inView[position.DeviceID]; ok {
+				delete(client.inView, position.DeviceID)
+				client.sendViewportEvent(ViewportLeave, position.DeviceID, position.Channel)
+			}
+		}
+	}
+}
+
+// Positions returns the latest positions that pass the filter, ordered by device ID
+func Positions(filter func(DevicePosition) bool) []DevicePosition {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	list := []DevicePosition{}
+	for _, latest := range latestPositions {
+		if filter(latest.position) {
+			list = append(list, latest.position)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DeviceID < list[j].DeviceID
+	})
+
+	return list
+}
+
+// PositionsWithin returns the latest positions inside the box that pass the
+// filter, ordered by device ID
+func PositionsWithin(box geo.BBox, filter func(DevicePosition) bool) []DevicePosition {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	list := []DevicePosition{}
+	for _, id := range positionIndex.Within(box) {
+		if position := latestPositions[id].position; filter(position) {
+			list = append(list, position)
+		}
+	}
+
+	return list
+}
+
+// Position returns the latest position of a device
+func Position(deviceID string) (DevicePosition, bool) {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	latest, ok := latestPositions[deviceID]
+	if !ok {
+		return DevicePosition{}, false
+	}
+
+	return latest.position, true
+}
+
+// envelopesIn returns the envelopes of the latest positions posted into any of
+// the channels, ordered by device ID. A box limits them to the positions inside it
+func envelopesIn(channels map[string]struct{}, box *geo.BBox) []*Envelope {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	var list []*Envelope
+	add := func(latest *latestPosition) {
+		if _, ok := channels[latest.position.Channel]; ok {
+			list = append(list, latest.envelope)
+		}
+	}
+
+	if box != nil {
+		for _, id := range positionIndex.Within(*box) {
+			add(latestPositions[id])
+		}
+		return list
+	}
+
+	for _, latest := range latestPositions {
+		add(latest)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DeviceID < list[j].DeviceID
+	})
+
+	return list
+}
+
+// latestEnvelopes returns the envelopes of every latest position. They are
+// never modified, so they can be read after positionsMutex is released
+func latestEnvelopes() []*Envelope {
+	positionsMutex.Lock()
+	defer positionsMutex.Unlock()
+
+	list := make([]*Envelope, 0, len(latestPositions))
+	for _, latest := range latestPositions {
+		list = append(list, latest.envelope)
+	}
+
+	return list
+}
+
+// sendSnapshot queues a snapshot frame with the latest positions on the
+// channels, or those inside the client's viewport if it has one. Clients that
+// get clusters are sent their clusters instead. connectionsMutex must be held
+// so no broadcast slips in between the snapshot and the live stream
+func (c *Client) sendSnapshot(channels map[string]struct{}) {
+	if c.clusteredLocked() {
+		c.clusters = nil
+		c.sendClustersLocked()
+		return
+	}
+
+	var positions []*Envelope
+	for _, env := range envelopesIn(channels, c.viewport) {
+		if !c.wants(env) {
+			continue
+		}
+
+		positions = append(positions, env)
+		if c.viewport != nil {
+			c.inView[env.DeviceID] = env.Channel
+		}
+	}
+
+	if positions == nil {
+		positions = []*Envelope{}
+	}
+
+	env, err := NewEnvelope(TypeSnapshot, "", positions)
+	if err != nil {
+		log.Println("Error building snapshot envelope:", err)
+		return
+	}
+
+	c.send(env)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// forgetDevices drops the state the test built up about the devices once it
+// is over, so it does not leak into other tests
+func forgetDevices(t *testing.T, ids ...string) {
+	t.Cleanup(func() {
+		connectionsMutex.Lock()
+		defer connectionsMutex.Unlock()
+
+		positionsMutex.Lock()
+		for _, id := range ids {
+			delete(latestPositions, id)
+			positionIndex.Remove(id)
+		}
+		positionsMutex.Unlock()
+
+		for _, id := range ids {
+			delete(onlineDevices, id)
+			delete(motions, id)
+			delete(routeTrackers, id)
+			forgetSequence(id)
+		}
+	})
+}
+
+func TestForgetPositions(t *testing.T) {
+	now := time.Now().UTC()
+	forgetDevices(t, "stale", "fresh")
+
+	for id, age := range map[string]time.Duration{"stale": PositionTTL + time.Minute, "fresh": time.Minute} {
+		env, err := NewLocationEnvelope("ttl-test", Location{DeviceID: id, Latitude: 10, Longitude: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.ServerTime = now.Add(-age)
+
+		recordPosition(env, Location{DeviceID: id, Latitude: 10, Longitude: 20})
+	}
+
+	connectionsMutex.Lock()
+	forgetPositionsLocked(now)
+	connectionsMutex.Unlock()
+
+	if _, ok := Position("stale"); ok {
+		t.Error("stale position was kept")
+	}
+
+	if _, ok := Position("fresh"); !ok {
+		t.Error("fresh position was dropped")
+	}
+
+	sequencesMutex.Lock()
+	_, staleSeq := sequences["stale"]
+	_, freshSeq := sequences["fresh"]
+	sequencesMutex.Unlock()
+
+	if staleSeq || !freshSeq {
+		t.Errorf("sequence kept for stale %v, fresh %v", staleSeq, freshSeq)
+	}
+
+	within := PositionsWithin(geo.BBox{MinLon: 19, MinLat: 9, MaxLon: 21, MaxLat: 11}, func(p DevicePosition) bool {
+		return p.Channel == "ttl-test"
+	})
+	if len(within) != 1 || within[0].DeviceID != "fresh" {
+		t.Errorf("index still holds %v", within)
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what happens when a client's send queue is full
+type OverflowPolicy string
+
+// Overflow policies
+const (
+	// OverflowDropOldest discards the oldest queued frame to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowLatest replaces the queued position of the same device, or the
+	// queued clusters, so only the latest of each is kept, and drops the
+	// oldest otherwise
+	OverflowLatest OverflowPolicy = "latest"
+
+	// OverflowDisconnect closes the connection of the slow consumer
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// Send queue settings applied to new connections
+var (
+	// SendQueueSize is the number of frames buffered per connection
+	SendQueueSize = 256
+
+	// Overflow is the policy applied when a connection's queue is full
+	Overflow = OverflowDropOldest
+)
+
+// ParseOverflowPolicy returns the overflow policy with the given name
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowDropOldest, OverflowLatest, OverflowDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", name)
+	}
+}
+
+// outbound is a frame waiting to be written to a client
+type outbound struct {
+	// Device ID for position updates, clustersKey for clusters and empty for
+	// other frames, which are never replaced
+	key string
+
+	// Event ID and message type of the envelope in data
+	id  uint64
+	typ string
+
+	data []byte
+}
+
+// sendQueue is a bounded queue of frames drained by a single writer goroutine
+type sendQueue struct {
+	mutex   sync.Mutex
+	items   []outbound
+	size    int
+	policy  OverflowPolicy
+	closed  bool
+	dropped uint64
+
+	// Set when the overflow policy closed the queue
+	overflowed bool
+
+	// Minimum time between batches handed to the writer, zero for none, and
+	// when the next batch may go out. While it is set, a queued frame is
+	// replaced by newer frames with the same key
+	interval time.Duration
+	next     time.Time
+
+	// Signals the writer that frames were queued or the queue was closed
+	wake chan struct{}
+}
+
+func newSendQueue(size int, policy OverflowPolicy) *sendQueue {
+	return &sendQueue{
+		size:   size,
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame without blocking. It returns false if the queue is closed,
+// including when the overflow policy just closed it
+func (q *sendQueue) push(item outbound) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	if q.interval > 0 {
+		// Moving the position to the back keeps it after frames queued since
+		if i := q.indexOf(item.key); i >= 0 {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+		}
+	}
+
+	if len(q.items) >= q.size {
+		q.dropped++
+
+		switch q.policy {
+		case OverflowLatest:
+			if i := q.indexOf(item.key); i >= 0 {
+				q.items[i] = item
+				return true
+			}
+			q.items = q.items[1:]
+		case OverflowDisconnect:
+			q.overflowed = true
+			q.closeLocked()
+			return false
+		default:
+			q.items = q.items[1:]
+		}
+	}
+
+	q.items = append(q.items, item)
+	q.signal()
+
+	return true
+}
+
+// pop waits for queued frames and returns all of them, no sooner than the
+// queue's interval after the previous batch. It returns false once the queue
+// is closed, and no frames with true if the timeout fires first. A nil
+// timeout waits forever
+func (q *sendQueue) pop(timeout <-chan time.Time) ([]outbound, bool) {
+	for {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return nil, false
+		}
+
+		var timer *time.Timer
+		var throttle <-chan time.Time
+		if len(q.items) > 0 {
+			now := time.Now()
+			if !now.Before(q.next) {
+				items := q.items
+				q.items = nil
+				q.next = now.Add(q.interval)
+				q.mutex.Unlock()
+				return items, true
+			}
+
+			timer = time.NewTimer(q.next.Sub(now))
+			throttle = timer.C
+		}
+		q.mutex.Unlock()
+
+		timedOut := false
+		select {
+		case <-q.wake:
+		case <-throttle:
+		case <-timeout:
+			timedOut = true
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if timedOut {
+			return nil, true
+		}
+	}
+}
+
+// setInterval sets the minimum time between batches handed to the writer
+func (q *sendQueue) setInterval(interval time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.interval = interval
+
+	// A shorter interval may let the waiting batch go out sooner
+	if next := time.Now().Add(interval); next.Before(q.next) {
+		q.next = next
+	}
+	q.signal()
+}
+
+// close discards queued frames and stops the writer
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closeLocked()
+}
+
+// stats returns the number of queued and dropped frames
+func (q *sendQueue) stats() (queued int, dropped uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items), q.dropped
+}
+
+// hasOverflowed reports whether the overflow policy closed the queue
+func (q *sendQueue) hasOverflowed() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.overflowed
+}
+
+func (q *sendQueue) closeLocked() {
+	q.closed = true
+	q.items = nil
+	q.signal()
+}
+
+// indexOf returns the position of the queued frame with the key, or -1
+func (q *sendQueue) indexOf(key string) int {
+	if key == "" {
+		return -1
+	}
+
+	for i, item := range q.items {
+		if item.key == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// signal wakes the writer without blocking, a pending wake-up is enough
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
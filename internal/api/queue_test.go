@@ -0,0 +1,288 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSendQueueOverflow(t *testing.T) {
+	frame := func(key, data string) outbound {
+		return outbound{key: key, data: []byte(data)}
+	}
+
+	tests := []struct {
+		name       string
+		policy     OverflowPolicy
+		push       []outbound
+		want       []string
+		dropped    uint64
+		overflowed bool
+	}{
+		{
+			name:   "within size",
+			policy: OverflowDropOldest,
+			push:   []outbound{frame("a", "a1"), frame("b", "b1")},
+			want:   []string{"a1", "b1"},
+		},
+		{
+			name:    "drop oldest",
+			policy:  OverflowDropOldest,
+			push:    []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("d", "d1"), frame("e", "e1")},
+			want:    []string{"c1", "d1", "e1"},
+			dropped: 2,
+		},
+		{
+			name:    "latest replaces the queued position of the same device",
+			policy:  OverflowLatest,
+			push:    []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("b", "b2"), frame("a", "a2")},
+			want:    []string{"a2", "b2", "c1"},
+			dropped: 2,
+		},
+		{
+			name:    "latest drops the oldest for new devices",
+			policy:  OverflowLatest,
+			push:    []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("d", "d1")},
+			want:    []string{"b1", "c1", "d1"},
+			dropped: 1,
+		},
+		{
+			name:    "latest drops the oldest for frames without a device",
+			policy:  OverflowLatest,
+			push:    []outbound{frame("", "e1"), frame("", "e2"), frame("", "e3"), frame("", "e4")},
+			want:    []string{"e2", "e3", "e4"},
+			dropped: 1,
+		},
+		{
+			name:    "latest replaces the queued clusters",
+			policy:  OverflowLatest,
+			push:    []outbound{frame(clustersKey, "k1"), frame("a", "a1"), frame("", "e1"), frame(clustersKey, "k2")},
+			want:    []string{"k2", "a1", "e1"},
+			dropped: 1,
+		},
+		{
+			name:       "disconnect",
+			policy:     OverflowDisconnect,
+			push:       []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("d", "d1")},
+			dropped:    1,
+			overflowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(3, tt.policy)
+			for _, item := range tt.push {
+				q.push(item)
+			}
+
+			_, dropped := q.stats()
+			if dropped != tt.dropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.dropped)
+			}
+
+			if q.hasOverflowed() != tt.overflowed {
+				t.Errorf("overflowed = %v, want %v", q.hasOverflowed(), tt.overflowed)
+			}
+
+			items, ok := q.pop(time.After(10 * time.Millisecond))
+			if tt.overflowed {
+				if ok {
+					t.Errorf("pop on an overflowed queue returned %d frames", len(items))
+				}
+				return
+			}
+
+			var got []string
+			for _, item := range items {
+				got = append(got, string(item.data))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueuePushAfterClose(t *testing.T) {
+	q := newSendQueue(3, OverflowDropOldest)
+	q.close()
+
+	if q.push(outbound{data: []byte("x")}) {
+		t.Error("push on a closed queue succeeded")
+	}
+
+	if _, ok := q.pop(nil); ok {
+		t.Error("pop on a closed queue succeeded")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, name := range []string{"drop-oldest", "latest", "disconnect"} {
+		if policy, err := ParseOverflowPolicy(name); err != nil || string(policy) != name {
+			t.Errorf("ParseOverflowPolicy(%q) = %q, %v", name, policy, err)
+		}
+	}
+
+	if _, err := ParseOverflowPolicy("block"); err == nil {
+		t.Error("ParseOverflowPolicy accepted an unknown policy")
+	}
+}
+
+func TestSendQueueCoalescing(t *testing.T) {
+	frame := func(key, data string) outbound {
+		return outbound{key: key, data: []byte(data)}
+	}
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		push     []outbound
+		want     []string
+		dropped  uint64
+	}{
+		{
+			name: "no interval keeps every position",
+			push: []outbound{frame("a", "a1"), frame("b", "b1"), frame("a", "a2")},
+			want: []string{"a1", "b1", "a2"},
+		},
+		{
+			name:     "newer position replaces the queued one",
+			interval: time.Hour,
+			push:     []outbound{frame("a", "a1"), frame("a", "a2"), frame("a", "a3")},
+			want:     []string{"a3"},
+		},
+		{
+			name:     "replacement moves to the back",
+			interval: time.Hour,
+			push:     []outbound{frame("a", "a1"), frame("b", "b1"), frame("", "e1"), frame("a", "a2")},
+			want:     []string{"b1", "e1", "a2"},
+		},
+		{
+			name:     "frames without a device are all kept",
+			interval: time.Hour,
+			push:     []outbound{frame("", "e1"), frame("", "e2"), frame("a", "a1"), frame("", "e3")},
+			want:     []string{"e1", "e2", "a1", "e3"},
+		},
+		{
+			name:     "newer clusters replace the queued ones",
+			interval: time.Hour,
+			push:     []outbound{frame(clustersKey, "k1"), frame("", "e1"), frame(clustersKey, "k2"), frame(clustersKey, "k3")},
+			want:     []string{"e1", "k3"},
+		},
+		{
+			name:     "coalescing is not dropping",
+			interval: time.Hour,
+			push:     []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("b", "b2"), frame("c", "c2")},
+			want:     []string{"a1", "b2", "c2"},
+		},
+		{
+			name:     "replacing in a full queue drops nothing",
+			interval: time.Hour,
+			push:     []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("d", "d1"), frame("a", "a2")},
+			want:     []string{"b1", "c1", "d1", "a2"},
+		},
+		{
+			name:     "new devices still overflow",
+			interval: time.Hour,
+			push:     []outbound{frame("a", "a1"), frame("b", "b1"), frame("c", "c1"), frame("d", "d1"), frame("e", "e1"), frame("a", "a2")},
+			want:     []string{"c1", "d1", "e1", "a2"},
+			dropped:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(4, OverflowDropOldest)
+
+			// The first batch goes out at once and starts the interval
+			q.setInterval(tt.interval)
+			q.push(frame("", "first"))
+			if items, _ := q.pop(time.After(10 * time.Millisecond)); len(items) != 1 {
+				t.Fatalf("first batch had %d frames", len(items))
+			}
+
+			for _, item := range tt.push {
+				q.push(item)
+			}
+
+			// Lift the interval so the coalesced batch is handed over
+			q.setInterval(0)
+
+			items, _ := q.pop(time.After(10 * time.Millisecond))
+
+			var got []string
+			for _, item := range items {
+				got = append(got, string(item.data))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %v, want %v", got, tt.want)
+			}
+
+			if _, dropped := q.stats(); dropped != tt.dropped {
+				t.Errorf("dropped = %d, want %d", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestSendQueueInterval(t *testing.T) {
+	q := newSendQueue(8, OverflowDropOldest)
+	q.setInterval(50 * time.Millisecond)
+
+	q.push(outbound{key: "a", data: []byte("a1")})
+	if items, _ := q.pop(time.After(10 * time.Millisecond)); len(items) != 1 {
+		t.Fatalf("first batch had %d frames, want 1", len(items))
+	}
+
+	q.push(outbound{key: "a", data: []byte("a2")})
+
+	// Held back until the interval has passed
+	if items, _ := q.pop(time.After(10 * time.Millisecond)); len(items) != 0 {
+		t.Fatalf("batch went out %d frames early", len(items))
+	}
+
+	began := time.Now()
+	items, ok := q.pop(time.After(time.Second))
+	if !ok || len(items) != 1 || string(items[0].data) != "a2" {
+		t.Fatalf("got %d frames, want a2", len(items))
+	}
+	if waited := time.Since(began); waited < 20*time.Millisecond {
+		t.Errorf("batch went out after %v", waited)
+	}
+
+	// A shorter interval releases the waiting batch sooner
+	q.setInterval(time.Hour)
+	q.push(outbound{key: "a", data: []byte("a3")})
+	q.pop(time.After(10 * time.Millisecond))
+	q.push(outbound{key: "a", data: []byte("a4")})
+
+	q.setInterval(0)
+	if items, _ := q.pop(time.After(100 * time.Millisecond)); len(items) != 1 || string(items[0].data) != "a4" {
+		t.Errorf("lifting the interval did not release the batch, got %d frames", len(items))
+	}
+}
+
+func TestNewFrameKey(t *testing.T) {
+	tests := []struct {
+		env  *Envelope
+		want string
+	}{
+		{env: &Envelope{Type: TypeLocation, DeviceID: "truck-1"}, want: "truck-1"},
+		{env: &Envelope{Type: TypeClusters}, want: clustersKey},
+		{env: &Envelope{Type: TypeViewport, DeviceID: "truck-1"}},
+		{env: &Envelope{Type: TypeSnapshot}},
+	}
+
+	for _, tt := range tests {
+		if frame := newFrame(tt.env, nil); frame.key != tt.want {
+			t.Errorf("%s frame key %q, want %q", tt.env.Type, frame.key, tt.want)
+		}
+	}
+
+	if deviceIDPattern.MatchString(clustersKey) {
+		t.Errorf("clusters key %q is a valid device ID", clustersKey)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// MinUpdateRate is the lowest maximum update rate, in updates per second, a
+// subscriber can ask for
+const MinUpdateRate = 0.01
+
+// validateRate reports an error if the maximum update rate is neither zero,
+// which means unlimited, nor at least MinUpdateRate
+func validateRate(rate float64) error {
+	if rate != 0 && (math.IsNaN(rate) || rate < MinUpdateRate) {
+		return fmt.Errorf("maxRate must be 0 or at least %g per second", MinUpdateRate)
+	}
+
+	return nil
+}
+
+// rateInterval returns the time between updates at the rate, zero for unlimited
+func rateInterval(rate float64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// requestRate sets the client's maximum update rate from the maxRate query
+// parameter, if the request has one
+func (c *Client) requestRate(ctx *fasthttp.RequestCtx) error {
+	value := ctx.QueryArgs().Peek("maxRate")
+	if len(value) == 0 {
+		return nil
+	}
+
+	rate, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return fmt.Errorf("maxRate %q is not a number", value)
+	}
+
+	if err := validateRate(rate); err != nil {
+		return err
+	}
+
+	c.maxRate = rate
+	c.queue.setInterval(rateInterval(rate))
+
+	return nil
+}
+
+// SetMaxRate limits how many times per second the client is sent updates.
+// Positions of a device arriving in between are coalesced so only the latest
+// goes out. A rate of zero lifts the limit
+func SetMaxRate(client *Client, rate float64) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	client.maxRate = rate
+	client.queue.setInterval(rateInterval(rate))
+}
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/nihankhan/locastream/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// TypeReplay messages report the state of a replay session
+const TypeReplay = "replay"
+
+// Replay control message types
+const (
+	ReplayPlay  = "play"
+	ReplayPause = "pause"
+	ReplaySeek  = "seek"
+	ReplaySpeed = "speed"
+)
+
+// Replay session states
+const (
+	ReplayPlaying = "playing"
+	ReplayPaused  = "paused"
+	ReplayEnded   = "ended"
+)
+
+// MaxReplaySpeed is the fastest a trip can be replayed
+const MaxReplaySpeed = 64
+
+// MaxReplayGap caps the real time waited between two replayed points, so a
+// device that was parked for hours doesn't stall the replay
+var MaxReplayGap = 5 * time.Second
+
+// ReplayControl is sent by replay clients to steer the session. Time is in
+// Unix milliseconds
+type ReplayControl struct {
+	Type  string  `json:"type"`
+	Time  int64   `json:"time,omitempty"`
+	Speed float64 `json:"speed,omitempty"`
+}
+
+// ReplayStatus is the payload of replay messages. Times are in Unix milliseconds
+type ReplayStatus struct {
+	State  string  `json:"state"`
+	Speed  float64 `json:"speed"`
+	From   int64   `json:"from"`
+	To     int64   `json:"to"`
+	Time   int64   `json:"time"`
+	Points int     `json:"points"`
+}
+
+// replaySession streams recorded points to a client with their original timing
+type replaySession struct {
+	client   *Client
+	deviceID string
+	records  []store.Record
+	speed    float64
+
+	// Index of the next record to send
+	next int
+
+	// Time of the last record sent
+	position time.Time
+
+	playing bool
+
+	// Control messages read from the client, applied by the session goroutine
+	controls chan ReplayControl
+
+	// after waits for the duration like time.After, replaced in tests
+	after func(time.Duration) <-chan time.Time
+}
+
+// ReplayWebSocket replays the recorded trip of a device over a WebSocket. The
+// deviceId, from and to query parameters select the trip and speed sets how
+// much faster than real time it plays, 1 by default. Points are sent as
+// location messages and the client steers playback with play, pause, seek and
+// speed control messages
+func ReplayWebSocket(ctx *fasthttp.RequestCtx) {
+	if !checkOrigin(ctx) {
+		return
+	}
+
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if !principal.Subscribe {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to subscribe")
+		return
+	}
+
+	if History == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "location history is disabled")
+		return
+	}
+
+	deviceID := string(ctx.QueryArgs().Peek("deviceId"))
+	if !deviceIDPattern.MatchString(deviceID) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid device ID %q", deviceID))
+		return
+	}
+
+	speed := 1.0
+	if ctx.QueryArgs().Has("speed") {
+		var err error
+		speed, err = ctx.QueryArgs().GetUfloat("speed")
+		if err != nil || !validReplaySpeed(speed) {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("speed must be above 0 and at most %d", MaxReplaySpeed))
+			return
+		}
+	}
+
+	query, err := historyQuery(ctx, deviceID)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := queryHistory(principal, query)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not read location history")
+		return
+	}
+
+	client := &Client{
+		transport: TransportWebSocket,
+		format:    requestFormat(ctx),
+		role:      RoleSubscriber,
+		deviceID:  deviceID,
+		channels:  make(map[string]struct{}),
+		principal: principal,
+		queue:     newSendQueue(SendQueueSize, Overflow),
+	}
+
+	session := &replaySession{
+		client:   client,
+		deviceID: deviceID,
+		records:  records,
+		speed:    speed,
+		playing:  true,
+		controls: make(chan ReplayControl, 16),
+		after:    time.After,
+	}
+
+	err = upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		client.conn = conn
+		client.connectedAt = time.Now().UTC()
+
+		// Replay clients are listed with the other connections but join no channels
+		AddConnection(client)
+		defer RemoveConnection(client)
+
+		go client.writeLoop()
+		defer client.queue.close()
+
+		done := make(chan struct{})
+		defer close(done)
+		go session.run(done)
+
+		conn.SetReadLimit(maxFrameSize)
+
+		for {
+			messageType, msg, err := conn.ReadMessage()
+			if err != nil {
+				log.Println("WebSocket read error:", err)
+				break
+			}
+
+			ctrl, verr := parseReplayControl(client.message(messageType, msg))
+			if verr != nil {
+				client.sendError(verr)
+				continue
+			}
+
+			session.controls <- ctrl
+		}
+	})
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+	}
+}
+
+// parseReplayControl decodes and checks a replay control message
+func parseReplayControl(msg message) (ReplayControl, *ValidationError) {
+	var ctrl ReplayControl
+	if err := msg.unmarshal(&ctrl); err != nil {
+		return ctrl, &ValidationError{Code: ErrCodeMalformed, Message: "replay control messages must be single objects"}
+	}
+
+	switch ctrl.Type {
+	case ReplayPlay, ReplayPause, ReplaySeek:
+	case ReplaySpeed:
+		if !validReplaySpeed(ctrl.Speed) {
+			return ctrl, &ValidationError{
+				Code:    ErrCodeOutOfRange,
+				Field:   "speed",
+				Message: fmt.Sprintf("speed must be above 0 and at most %d", MaxReplaySpeed),
+			}
+		}
+	default:
+		return ctrl, &ValidationError{
+			Code:    ErrCodeUnknownType,
+			Field:   "type",
+			Message: fmt.Sprintf("unknown replay control message type %q", ctrl.Type),
+		}
+	}
+
+	return ctrl, nil
+}
+
+// validReplaySpeed reports whether the speed can be replayed at
+func validReplaySpeed(speed float64) bool {
+	return speed > 0 && speed <= MaxReplaySpeed
+}
+
+// run plays the session until it is done, applying control messages as they arrive
+func (s *replaySession) run(done <-chan struct{}) {
+	s.sendStatus()
+
+	wake := s.after(0)
+
+	for {
+		var tick <-chan time.Time
+		if s.playing {
+			tick = wake
+		}
+
+		select {
+		case <-done:
+			return
+		case ctrl := <-s.controls:
+			s.apply(ctrl)
+
+			// Restart the wait so speed and position changes take effect immediately
+			if s.playing {
+				wake = s.after(s.wait())
+			}
+		case <-tick:
+			s.sendNext()
+
+			if s.next >= len(s.records) {
+				s.playing = false
+				s.sendStatus()
+				continue
+			}
+
+			wake = s.after(s.wait())
+		}
+	}
+}
+
+// apply changes the session as asked by a control message
+func (s *replaySession) apply(ctrl ReplayControl) {
+	switch ctrl.Type {
+	case ReplayPlay:
+		// Playing an ended trip starts it over
+		if s.next >= len(s.records) {
+			s.next = 0
+		}
+		s.playing = true
+	case ReplayPause:
+		s.playing = false
+	case ReplaySeek:
+		at := time.UnixMilli(ctrl.Time)
+		s.next = sort.Search(len(s.records), func(i int) bool {
+			return !s.records[i].Time.Before(at)
+		})
+		s.position = at
+
+		// Show where the device was at the new position, even while paused
+		if s.next > 0 {
+			s.next--
+			s.sendNext()
+		}
+	case ReplaySpeed:
+		s.speed = ctrl.Speed
+	}
+
+	s.sendStatus()
+}
+
+// wait returns how long to wait before sending the next record
+func (s *replaySession) wait() time.Duration {
+	if s.position.IsZero() || s.next >= len(s.records) {
+		return 0
+	}
+
+	gap := s.records[s.next].Time.Sub(s.position)
+	if gap < 0 {
+		return 0
+	}
+
+	wait := time.Duration(float64(gap) / s.speed)
+
+	if wait > MaxReplayGap {
+		wait = MaxReplayGap
+	}
+
+	return wait
+}
+
+// sendNext sends the next record as the location message it was broadcast as
+func (s *replaySession) sendNext() {
+	if s.next >= len(s.records) {
+		return
+	}
+
+	r := s.records[s.next]
+	s.next++
+	s.position = r.Time
+
+	s.client.send(recordEnvelope(r))
+}
+
+// sendStatus reports the state of the session to the client
+func (s *replaySession) sendStatus() {
+	status := ReplayStatus{
+		State:  ReplayPaused,
+		Speed:  s.speed,
+		Time:   unixMilli(s.position),
+		Points: len(s.records),
+	}
+
+	switch {
+	case s.next >= len(s.records):
+		status.State = ReplayEnded
+	case s.playing:
+		status.State = ReplayPlaying
+	}
+
+	if len(s.records) > 0 {
+		status.From = unixMilli(s.records[0].Time)
+		status.To = unixMilli(s.records[len(s.records)-1].Time)
+	}
+
+	env, err := NewEnvelope(TypeReplay, s.deviceID, status)
+	if err != nil {
+		log.Println("Error building replay envelope:", err)
+		return
+	}
+
+	s.client.send(env)
+}
+
+// unixMilli returns the time in Unix milliseconds, 0 for the zero time
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/store"
+)
+
+// fakeClock hands replay sessions timers the test fires by hand
+type fakeClock struct {
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{waits: make(chan time.Duration), fire: make(chan time.Time)}
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.fire
+}
+
+// next returns the wait the session asked for, failing if it asks for none
+func (c *fakeClock) next(t *testing.T) time.Duration {
+	t.Helper()
+
+	select {
+	case d := <-c.waits:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("session did not start a wait")
+		return 0
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	record := func(seq uint64, offset time.Duration, distance float64) store.Record {
+		motion, err := json.Marshal(Motion{StartedAt: start, Distance: distance})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store.Record{
+			DeviceID: "replay-1",
+			Channel:  "fleet",
+			Seq:      seq,
+			Time:     start.Add(offset),
+			Payload:  json.RawMessage(`{"deviceId":"replay-1","latitude":1,"longitude":2}`),
+			Motion:   motion,
+			Route:    json.RawMessage(`{"latitude":1,"longitude":2,"along":10,"remaining":90,"crossTrack":0,"speed":1}`),
+		}
+	}
+
+	clock := newFakeClock()
+	client := &Client{queue: newSendQueue(SendQueueSize, Overflow)}
+	session := &replaySession{
+		client:   client,
+		deviceID: "replay-1",
+		records:  []store.Record{record(1, 0, 0), record(2, 2*time.Second, 20), record(3, 10*time.Second, 100)},
+		speed:    2,
+		playing:  true,
+		controls: make(chan ReplayControl),
+		after:    clock.after,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go session.run(done)
+
+	// sent returns what the session sent since the last call, as seq numbers
+	// of location messages and states of replay messages
+	sent := func() []string {
+		var got []string
+		for _, env := range receivedEnvelopes(t, client) {
+			switch env.Type {
+			case TypeLocation:
+				if env.Motion == nil || env.Route == nil || env.Route.Remaining != 90 {
+					t.Errorf("seq %d replayed with motion %+v and route %+v", env.Seq, env.Motion, env.Route)
+				}
+				got = append(got, fmt.Sprintf("seq %d", env.Seq))
+			case TypeReplay:
+				var status ReplayStatus
+				if err := json.Unmarshal(env.Payload, &status); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, status.State)
+			}
+		}
+		return got
+	}
+
+	expect := func(step string, want ...string) {
+		t.Helper()
+
+		got := sent()
+		if len(got) != len(want) {
+			t.Fatalf("%s: sent %v, want %v", step, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s: sent %v, want %v", step, got, want)
+			}
+		}
+	}
+
+	expectWait := func(step string, want time.Duration) {
+		t.Helper()
+
+		if got := clock.next(t); got != want {
+			t.Fatalf("%s: waits %v, want %v", step, got, want)
+		}
+	}
+
+	control := func(ctrl ReplayControl) {
+		session.controls <- ctrl
+	}
+
+	expectWait("start", 0)
+	expect("start", ReplayPlaying)
+
+	clock.fire <- time.Time{}
+	expectWait("first point", time.Second)
+	expect("first point", "seq 1")
+
+	// Nothing is sent while paused, and playing resumes at the new speed
+	control(ReplayControl{Type: ReplayPause})
+	control(ReplayControl{Type: ReplaySpeed, Speed: 4})
+	control(ReplayControl{Type: ReplayPlay})
+	expectWait("play at 4x", 500*time.Millisecond)
+	expect("play at 4x", ReplayPaused, ReplayPaused, ReplayPlaying)
+
+	clock.fire <- time.Time{}
+	expectWait("second point", 2*time.Second)
+	expect("second point", "seq 2")
+
+	// Seeking shows the point before the new position and waits from there
+	control(ReplayControl{Type: ReplaySeek, Time: start.Add(time.Second).UnixMilli()})
+	expectWait("seek", 500*time.Millisecond)
+	expect("seek", "seq 1", ReplayPlaying)
+
+	clock.fire <- time.Time{}
+	expectWait("after seek", 2*time.Second)
+
+	// Long gaps are capped at MaxReplayGap of real time
+	control(ReplayControl{Type: ReplaySpeed, Speed: 1})
+	expectWait("slow down", MaxReplayGap)
+	expect("slow down", "seq 2", ReplayPlaying)
+
+	// Playing an ended trip starts it over
+	clock.fire <- time.Time{}
+	control(ReplayControl{Type: ReplayPlay})
+	expectWait("start over", 0)
+	expect("end", "seq 3", ReplayEnded, ReplayPlaying)
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// errorResponse is the body of JSON error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes the value as a JSON response with the given status code
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error encoding response:", err)
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.SetBody(data)
+}
+
+// writeError writes a JSON error response with the given status code
+func writeError(ctx *fasthttp.RequestCtx, status int, message string) {
+	writeJSON(ctx, status, errorResponse{Error: message})
+}
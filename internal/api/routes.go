@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+	"github.com/nihankhan/locastream/internal/route"
+	"github.com/valyala/fasthttp"
+)
+
+// RecentSpeedWindow is how far back progress along a route is averaged to
+// estimate the arrival time
+var RecentSpeedWindow = 2 * time.Minute
+
+// MinETASpeed is the recent speed in meters per second below which a device is
+// considered stopped and no arrival time is estimated
+const MinETASpeed = 0.5
+
+// Routes holds the planned routes of devices. Route tracking is disabled while
+// it is nil
+var Routes *route.Registry
+
+// RouteProgress is where a device stands on its planned route
+type RouteProgress struct {
+	// Latitude and Longitude of the device snapped onto the route
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+
+	// Along is how far along the route the device is and Remaining how far it
+	// still has to go, in meters
+	Along     float64 `json:"along"`
+	Remaining float64 `json:"remaining"`
+
+	// CrossTrack is the distance in meters between the device and the route
+	CrossTrack float64 `json:"crossTrack"`
+
+	// Speed is the recent progress along the route in meters per second
+	Speed float64 `json:"speed"`
+
+	// ETA and RemainingTime in seconds are estimated from the recent speed,
+	// and left out while the device is stopped
+	ETA           *time.Time `json:"eta,omitempty"`
+	RemainingTime float64    `json:"remainingTime,omitempty"`
+
+	// OffRoute is set while the device is off its route
+	OffRoute bool `json:"offRoute"`
+}
+
+// routeSample is the progress along the route at the time of a fix
+type routeSample struct {
+	at    time.Time
+	along float64
+}
+
+// routeTracker follows a device along its route
+type routeTracker struct {
+	route   *route.Route
+	samples []routeSample
+
+	// Whether the device is off the route, and how many fixes in a row
+	// disagreed with that
+	offRoute bool
+	streak   int
+
+	// Segment the last fix was snapped to, where the next search starts
+	segment int
+	snapped bool
+}
+
+// Map of device ID to its progress along its route, guarded by connectionsMutex
+var routeTrackers = make(map[string]*routeTracker)
+
+// RouteResponse is a device's planned route and its latest progress along it
+type RouteResponse struct {
+	Route    *route.Route   `json:"route"`
+	Progress *RouteProgress `json:"progress,omitempty"`
+}
+
+// updateRouteLocked snaps an accepted update onto its device's planned route
+// and returns the progress along it, or nil if the device has no route,
+// connectionsMutex must be held
+func updateRouteLocked(env *Envelope, location Location) *RouteProgress {
+	if Routes == nil {
+		return nil
+	}
+
+	// A route only applies to updates on its own channel
+	r, ok := Routes.Get(env.DeviceID)
+	if !ok || r.Channel != env.Channel {
+		delete(routeTrackers, env.DeviceID)
+		return nil
+	}
+
+	// Setting a new route starts the estimate over
+	tracker, ok := routeTrackers[env.DeviceID]
+	if !ok || tracker.route != r {
+		tracker = &routeTracker{route: r}
+		routeTrackers[env.DeviceID] = tracker
+	}
+
+	// Devices move along their route, so the search starts where the last fix was
+	var snap geo.Snap
+	if tracker.snapped {
+		distance, _ := offRouteThresholds(r)
+		snap = r.SnapNear(location.Latitude, location.Longitude, tracker.segment, distance)
+	} else {
+		snap = r.Snap(location.Latitude, location.Longitude)
+	}
+	tracker.segment, tracker.snapped = snap.Segment, true
+
+	at := fixTime(env, location)
+
+	progress := &RouteProgress{
+		Latitude:   snap.Lat,
+		Longitude:  snap.Lon,
+		Along:      snap.Along,
+		Remaining:  r.Length - snap.Along,
+		CrossTrack: snap.Distance,
+	}
+
+	if n := len(tracker.samples); n == 0 || !at.Before(tracker.samples[n-1].at) {
+		tracker.samples = append(tracker.samples, routeSample{at: at, along: snap.Along})
+	}
+
+	// Keep the samples inside the window
+	cutoff := at.Add(-RecentSpeedWindow)
+	drop := 0
+	for drop < len(tracker.samples)-1 && tracker.samples[drop].at.Before(cutoff) {
+		drop++
+	}
+	tracker.samples = tracker.samples[drop:]
+
+	first, last := tracker.samples[0], tracker.samples[len(tracker.samples)-1]
+	if dt := last.at.Sub(first.at).Seconds(); dt > 0 {
+		progress.Speed = (last.along - first.along) / dt
+	} else if env.Motion != nil {
+		progress.Speed = env.Motion.Speed
+	}
+
+	checkDeviationLocked(tracker, env, location, progress, at)
+
+	if progress.Speed >= MinETASpeed {
+		progress.RemainingTime = progress.Remaining / progress.Speed
+
+		eta := at.Add(time.Duration(progress.RemainingTime * float64(time.Second)))
+		progress.ETA = &eta
+	}
+
+	return progress
+}
+
+// GetRoute serves the planned route of the device named in the path and its
+// latest progress along it
+func GetRoute(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !routesEnabled(ctx) {
+		return
+	}
+
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+
+	r, ok := Routes.Get(deviceID)
+	if !ok || !principal.CanAccess(r.Channel) {
+		writeError(ctx, fasthttp.StatusNotFound, "no route for device "+deviceID)
+		return
+	}
+
+	// The progress comes from the device's latest position, which may be on
+	// a channel the caller can't see
+	response := RouteResponse{Route: r}
+	if position, ok := Position(deviceID); ok && principal.CanAccess(position.Channel) {
+		response.Progress = position.Route
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, response)
+}
+
+// SetRoute stores the planned route in the request body for the device named
+// in the path, replacing any it had
+func SetRoute(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !routesEnabled(ctx) {
+		return
+	}
+
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+	if !deviceIDPattern.MatchString(deviceID) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid device ID %q", deviceID))
+		return
+	}
+
+	var r route.Route
+	dec := json.NewDecoder(bytes.NewReader(ctx.PostBody()))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&r); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, "invalid route: "+err.Error())
+		return
+	}
+
+	r.DeviceID = deviceID
+	if r.Channel == "" {
+		r.Channel = DefaultChannel
+	}
+
+	if err := validateChannel(r.Channel); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !principal.CanAccess(r.Channel) {
+		writeError(ctx, fasthttp.StatusForbidden, "access to channel "+r.Channel+" denied")
+		return
+	}
+
+	// Replacing a route needs access to the one being replaced too
+	if previous, ok := Routes.Get(deviceID); ok && !principal.CanAccess(previous.Channel) {
+		writeError(ctx, fasthttp.StatusForbidden, "access to channel "+previous.Channel+" denied")
+		return
+	}
+
+	saved, err := Routes.Set(r)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, RouteResponse{Route: saved})
+}
+
+// DeleteRoute removes the planned route of the device named in the path
+func DeleteRoute(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !routesEnabled(ctx) {
+		return
+	}
+
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+
+	r, ok := Routes.Get(deviceID)
+	if !ok || !principal.CanAccess(r.Channel) {
+		writeError(ctx, fasthttp.StatusNotFound, "no route for device "+deviceID)
+		return
+	}
+
+	if err := Routes.Remove(deviceID); err != nil {
+		if errors.Is(err, route.ErrNotFound) {
+			writeError(ctx, fasthttp.StatusNotFound, "no route for device "+deviceID)
+			return
+		}
+
+		log.Println("Error removing route:", err)
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not remove route")
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// routesEnabled replies 503 if route tracking is disabled
+func routesEnabled(ctx *fasthttp.RequestCtx) bool {
+	if Routes == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "route tracking is disabled")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/route"
+)
+
+func TestCheckDeviation(t *testing.T) {
+	subscriber := &Client{
+		role:     RoleSubscriber,
+		channels: map[string]struct{}{EventChannel("offroute-test"): {}},
+		queue:    newSendQueue(SendQueueSize, Overflow),
+	}
+
+	AddConnection(subscriber)
+	t.Cleanup(func() {
+		RemoveConnection(subscriber)
+	})
+	receivedEnvelopes(t, subscriber)
+
+	type fix struct {
+		crossTrack float64
+
+		// Whether the device is off its route after the fix, and the event it raises
+		offRoute bool
+		event    string
+	}
+
+	tests := []struct {
+		name  string
+		route route.Route
+		fixes []fix
+	}{
+		{
+			name:  "streak and hysteresis",
+			route: route.Route{OffRouteDistance: 100, OffRouteFixes: 3},
+			fixes: []fix{
+				{crossTrack: 10},
+				{crossTrack: 150},
+				{crossTrack: 150},
+				// One fix back on the route breaks the streak
+				{crossTrack: 50},
+				{crossTrack: 150},
+				{crossTrack: 150},
+				{crossTrack: 150, offRoute: true, event: RouteOff},
+				{crossTrack: 400, offRoute: true},
+				{crossTrack: 20, offRoute: true},
+				{crossTrack: 20, offRoute: true},
+				// Coming back takes as many fixes in a row as leaving
+				{crossTrack: 150, offRoute: true},
+				{crossTrack: 20, offRoute: true},
+				{crossTrack: 20, offRoute: true},
+				{crossTrack: 100, event: RouteBack},
+				{crossTrack: 20},
+			},
+		},
+		{
+			name:  "route thresholds",
+			route: route.Route{OffRouteDistance: 30, OffRouteFixes: 1},
+			fixes: []fix{
+				{crossTrack: 30},
+				{crossTrack: 31, offRoute: true, event: RouteOff},
+				{crossTrack: 10, event: RouteBack},
+			},
+		},
+		{
+			name:  "defaults",
+			route: route.Route{},
+			fixes: []fix{
+				{crossTrack: OffRouteDistance + 1},
+				{crossTrack: OffRouteDistance + 1},
+				{crossTrack: OffRouteDistance + 1, offRoute: true, event: RouteOff},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.route
+			tracker := &routeTracker{route: &r}
+			at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			for i, f := range tt.fixes {
+				env := &Envelope{DeviceID: "truck-42", Channel: "offroute-test"}
+				progress := &RouteProgress{CrossTrack: f.crossTrack, Along: float64(i) * 10}
+
+				connectionsMutex.Lock()
+				checkDeviationLocked(tracker, env, Location{DeviceID: "truck-42"}, progress, at)
+				unlockConnections()
+
+				if progress.OffRoute != f.offRoute {
+					t.Errorf("fix %d at %vm: off route %v, want %v", i, f.crossTrack, progress.OffRoute, f.offRoute)
+				}
+
+				var events []string
+				for _, env := range receivedEnvelopes(t, subscriber) {
+					var event RouteEvent
+					if err := json.Unmarshal(env.Payload, &event); err != nil {
+						t.Fatal(err)
+					}
+
+					if env.Type != TypeRoute || env.Channel != EventChannel("offroute-test") || event.CrossTrack != f.crossTrack {
+						t.Errorf("fix %d: unexpected %s message %s on %s", i, env.Type, env.Payload, env.Channel)
+					}
+					events = append(events, event.Type)
+				}
+
+				var want []string
+				if f.event != "" {
+					want = []string{f.event}
+				}
+
+				if len(events) != len(want) || (len(want) > 0 && events[0] != want[0]) {
+					t.Errorf("fix %d at %vm: events %v, want %v", i, f.crossTrack, events, want)
+				}
+			}
+		})
+	}
+}
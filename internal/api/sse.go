@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HeartbeatInterval is how often an idle event stream gets a comment line, which
+// keeps proxies from timing it out and detects clients that went away
+var HeartbeatInterval = 15 * time.Second
+
+// Stream serves the broadcast of the requested channels as Server-Sent Events,
+// for consumers that can't use WebSockets. The deviceId query parameter limits
+// the stream to some devices, and a Last-Event-ID header or lastEventId query
+// parameter resumes it after a reconnect
+func Stream(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if !principal.Subscribe {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to subscribe")
+		return
+	}
+
+	names, err := requestChannels(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := &Client{
+		transport:   TransportSSE,
+		role:        RoleSubscriber,
+		connectedAt: time.Now().UTC(),
+		channels:    make(map[string]struct{}),
+		principal:   principal,
+		queue:       newSendQueue(SendQueueSize, Overflow),
+	}
+
+	for _, name := range names {
+		if !principal.CanAccess(name) {
+			writeError(ctx, fasthttp.StatusForbidden, "access to channel "+name+" denied")
+			return
+		}
+		client.channels[name] = struct{}{}
+	}
+
+	for _, value := range ctx.QueryArgs().PeekMulti("deviceId") {
+		for _, id := range strings.Split(string(value), ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+
+			if client.devices == nil {
+				client.devices = make(map[string]struct{})
+			}
+			client.devices[id] = struct{}{}
+		}
+	}
+
+	if err := client.requestViewport(ctx); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := client.requestZoom(ctx); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := client.requestRate(ctx); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	lastID := string(ctx.Request.Header.Peek("Last-Event-ID"))
+	if lastID == "" {
+		lastID = string(ctx.QueryArgs().Peek("lastEventId"))
+	}
+
+	var resumeFrom uint64
+	if lastID != "" {
+		resumeFrom, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		addStreamClient(client, resumeFrom, lastID != "")
+		defer RemoveConnection(client)
+		defer client.queue.close()
+
+		client.streamLoop(w)
+	})
+}
+
+// addStreamClient registers an event stream client. A resuming client gets the
+// broadcasts it missed, or a snapshot if they are no longer in the backlog
+func addStreamClient(client *Client, lastID uint64, resume bool) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	registerLocked(client)
+
+	if resume {
+		if missed, ok := eventsSince(lastID); ok {
+			for _, env := range missed {
+				client.deliverLocked(env, newFrameSet(env))
+			}
+			return
+		}
+	}
+
+	client.sendSnapshot(client.channels)
+}
+
+// streamLoop writes queued frames as events until the client goes away or the
+// queue is closed
+func (c *Client) streamLoop(w *bufio.Writer) {
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		items, ok := c.queue.pop(heartbeat.C)
+		if !ok {
+			if c.queue.hasOverflowed() {
+				log.Printf("Disconnecting slow client %d: send queue overflowed", c.id)
+			}
+			return
+		}
+
+		if len(items) == 0 {
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		for _, item := range items {
+			if item.id != 0 {
+				fmt.Fprintf(w, "id: %d\n", item.id)
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", item.typ, item.data)
+		}
+
+		if err := w.Flush(); err != nil {
+			// The client went away
+			return
+		}
+	}
+}
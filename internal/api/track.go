@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/export"
+	"github.com/nihankhan/locastream/internal/geo"
+	"github.com/nihankhan/locastream/internal/store"
+	"github.com/valyala/fasthttp"
+)
+
+// Track query limits
+const (
+	// MaxTrackRecords is the most records read from the history for one
+	// query. Longer histories keep their newest records
+	MaxTrackRecords = 200000
+
+	// DefaultTrackPoints is the number of points a track is reduced to when
+	// maxPoints is not given
+	DefaultTrackPoints = 1000
+)
+
+// Downsampling methods for tracks
+const (
+	SimplifyDouglasPeucker = "dp"
+	SimplifyTimeBucket     = "bucket"
+)
+
+// TrackPoint is a recorded position of a device
+type TrackPoint struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Time      time.Time `json:"time"`
+	Seq       uint64    `json:"seq"`
+}
+
+// Track is the recorded path of a device in a time range
+type Track struct {
+	DeviceID  string       `json:"deviceId"`
+	From      *time.Time   `json:"from,omitempty"`
+	To        *time.Time   `json:"to,omitempty"`
+	Recorded  int          `json:"recorded"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Points    []TrackPoint `json:"points"`
+}
+
+// GetTrack serves the recorded path of the device named in the path. The from
+// and to query parameters bound the time range, maxPoints caps the number of
+// points with the downsampling method named by simplify, and format selects
+// json points or a geojson LineString feature
+func GetTrack(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+
+	if History == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "location history is disabled")
+		return
+	}
+
+	args := ctx.QueryArgs()
+	deviceID := fmt.Sprint(ctx.UserValue("id"))
+
+	query, err := historyQuery(ctx, deviceID)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	maxPoints := DefaultTrackPoints
+	if args.Has("maxPoints") {
+		maxPoints, err = args.GetUint("maxPoints")
+		if err != nil || maxPoints < 2 {
+			writeError(ctx, fasthttp.StatusBadRequest, "maxPoints must be an integer of at least 2")
+			return
+		}
+	}
+
+	records, truncated, err := queryNewestHistory(principal, query)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not read location history")
+		return
+	}
+
+	points := make([]geo.Point, len(records))
+	for i, r := range records {
+		points[i] = geo.Point{Lat: r.Latitude, Lon: r.Longitude, Time: r.Time}
+	}
+
+	var kept []int
+	switch method := string(args.Peek("simplify")); method {
+	case "", SimplifyDouglasPeucker:
+		kept = geo.SimplifyDP(points, maxPoints)
+	case SimplifyTimeBucket:
+		kept = geo.BucketByTime(points, maxPoints)
+	default:
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("unknown simplify method %q", method))
+		return
+	}
+
+	track := Track{
+		DeviceID:  deviceID,
+		Recorded:  len(records),
+		Truncated: truncated,
+		Points:    make([]TrackPoint, 0, len(kept)),
+	}
+
+	if !query.From.IsZero() {
+		track.From = &query.From
+	}
+	if !query.To.IsZero() {
+		track.To = &query.To
+	}
+
+	for _, i := range kept {
+		r := records[i]
+		track.Points = append(track.Points, TrackPoint{
+			Latitude:  r.Latitude,
+			Longitude: r.Longitude,
+			Time:      r.Time,
+			Seq:       r.Seq,
+		})
+	}
+
+	switch format := string(args.Peek("format")); format {
+	case "", "json":
+		writeJSON(ctx, fasthttp.StatusOK, track)
+	case "geojson":
+		feature := export.TrackFeature(export.Track{DeviceID: deviceID, Points: keptRecords(records, kept)})
+		feature.Properties["recorded"] = track.Recorded
+		if truncated {
+			feature.Properties["truncated"] = true
+		}
+
+		writeJSON(ctx, fasthttp.StatusOK, feature)
+		ctx.SetContentType("application/geo+json")
+	default:
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("unknown format %q", format))
+	}
+}
+
+// historyQuery builds a history query for the device from the from and to
+// query parameters. It reads one record past MaxTrackRecords so queryHistory
+// can tell when older records were left out
+func historyQuery(ctx *fasthttp.RequestCtx, deviceID string) (store.Query, error) {
+	query := store.Query{DeviceID: deviceID, Limit: MaxTrackRecords + 1, Newest: true}
+
+	var err error
+	if query.From, err = parseTime(string(ctx.QueryArgs().Peek("from"))); err != nil {
+		return query, fmt.Errorf("invalid from: %v", err)
+	}
+
+	if query.To, err = parseTime(string(ctx.QueryArgs().Peek("to"))); err != nil {
+		return query, fmt.Errorf("invalid to: %v", err)
+	}
+
+	if !query.From.IsZero() && !query.To.IsZero() && query.To.Before(query.From) {
+		return query, fmt.Errorf("to is before from")
+	}
+
+	return query, nil
+}
+
+// queryHistory reads the records selected by the query on channels the
+// principal may access, at most the newest MaxTrackRecords
+func queryHistory(principal *auth.Principal, query store.Query) ([]store.Record, error) {
+	records, _, err := queryNewestHistory(principal, query)
+
+	return records, err
+}
+
+// queryNewestHistory is queryHistory that also reports whether older records
+// were left out to stay within MaxTrackRecords
+func queryNewestHistory(principal *auth.Principal, query store.Query) ([]store.Record, bool, error) {
+	records, err := History.Query(query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := len(records) > MaxTrackRecords
+	if truncated {
+		records = records[len(records)-MaxTrackRecords:]
+	}
+
+	visible := records[:0]
+	for _, r := range records {
+		if principal.CanAccess(r.Channel) {
+			visible = append(visible, r)
+		}
+	}
+
+	return visible, truncated, nil
+}
+
+// parseTime parses an RFC 3339 time or Unix milliseconds. An empty value is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// keptRecords returns the records at the kept indices
+func keptRecords(records []store.Record, kept []int) []store.Record {
+	out := make([]store.Record, len(kept))
+	for i, k := range kept {
+		out[i] = records[k]
+	}
+
+	return out
+}
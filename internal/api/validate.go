@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Limits applied to incoming location updates
+const (
+	// MaxPayloadSize is the largest location update accepted, in bytes
+	MaxPayloadSize = 4096
+
+	// MaxClockSkew is how far into the future a device timestamp may be
+	MaxClockSkew = 5 * time.Minute
+
+	// MaxLocationAge is how far into the past a device timestamp may be
+	MaxLocationAge = 24 * time.Hour
+)
+
+// Error codes sent back to publishers in error frames
+const (
+	ErrCodeTooLarge       = "payload_too_large"
+	ErrCodeMalformed      = "malformed_payload"
+	ErrCodeUnknownField   = "unknown_field"
+	ErrCodeMissingField   = "missing_field"
+	ErrCodeOutOfRange     = "out_of_range"
+	ErrCodeDeviceMismatch = "device_mismatch"
+	ErrCodeReadOnly       = "read_only"
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeInvalidChannel = "invalid_channel"
+	ErrCodeInvalidBBox    = "invalid_bbox"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ValidationError describes why an incoming update was rejected. It is sent
+// back to the publisher as the payload of an error frame
+type ValidationError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, e.Field)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// incomingLocation mirrors Location with pointer fields so that missing values
+// can be told apart from zero values
+type incomingLocation struct {
+	DeviceID  string   `json:"deviceId"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Timestamp *int64   `json:"timestamp"`
+	Distance  *float64 `json:"distance"`
+	Duration  *float64 `json:"duration"`
+}
+
+// ParseLocation decodes and validates a raw location update. Only the fields of
+// Location are accepted, coordinates must be in range and the optional device
+// timestamp must be close to now. The returned Location is normalized and safe
+// to re-serialize
+func ParseLocation(msg []byte, now time.Time) (Location, *ValidationError) {
+	return parseLocation(message{data: msg}, now)
+}
+
+// parseLocation decodes and validates a location update in either wire format
+func parseLocation(msg message, now time.Time) (Location, *ValidationError) {
+	if len(msg.data) > MaxPayloadSize {
+		return Location{}, &ValidationError{
+			Code:    ErrCodeTooLarge,
+			Message: fmt.Sprintf("payload is %d bytes, the limit is %d", len(msg.data), MaxPayloadSize),
+		}
+	}
+
+	var in incomingLocation
+	if err := msg.unmarshal(&in); err != nil {
+		return Location{}, decodeError(err)
+	}
+
+	if in.Latitude == nil {
+		return Location{}, &ValidationError{Code: ErrCodeMissingField, Field: "latitude", Message: "latitude is required"}
+	}
+
+	if in.Longitude == nil {
+		return Location{}, &ValidationError{Code: ErrCodeMissingField, Field: "longitude", Message: "longitude is required"}
+	}
+
+	if err := checkRange("latitude", *in.Latitude, -90, 90); err != nil {
+		return Location{}, err
+	}
+
+	if err := checkRange("longitude", *in.Longitude, -180, 180); err != nil {
+		return Location{}, err
+	}
+
+	location := Location{
+		DeviceID:  in.DeviceID,
+		Latitude:  *in.Latitude,
+		Longitude: *in.Longitude,
+	}
+
+	if in.Distance != nil {
+		if err := checkRange("distance", *in.Distance, 0, math.MaxFloat64); err != nil {
+			return Location{}, err
+		}
+		location.Distance = *in.Distance
+	}
+
+	if in.Duration != nil {
+		if err := checkRange("duration", *in.Duration, 0, math.MaxFloat64); err != nil {
+			return Location{}, err
+		}
+		location.Duration = *in.Duration
+	}
+
+	if in.Timestamp != nil {
+		ts := time.UnixMilli(*in.Timestamp)
+		if ts.After(now.Add(MaxClockSkew)) {
+			return Location{}, &ValidationError{Code: ErrCodeOutOfRange, Field: "timestamp", Message: "timestamp is in the future"}
+		}
+		if ts.Before(now.Add(-MaxLocationAge)) {
+			return Location{}, &ValidationError{Code: ErrCodeOutOfRange, Field: "timestamp", Message: "timestamp is too old"}
+		}
+		location.Timestamp = *in.Timestamp
+	}
+
+	return location, nil
+}
+
+// checkRange reports an error if the value is not a finite number within [min, max]
+func checkRange(field string, value, min, max float64) *ValidationError {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < min || value > max {
+		return &ValidationError{
+			Code:    ErrCodeOutOfRange,
+			Field:   field,
+			Message: fmt.Sprintf("%s must be between %g and %g", field, min, max),
+		}
+	}
+
+	return nil
+}
+
+// decodeError turns a decoding error into a validation error
+func decodeError(err error) *ValidationError {
+	// Anything after the first value is not a single location update
+	if errors.Is(err, errTrailing) {
+		return &ValidationError{Code: ErrCodeMalformed, Message: "payload must be a single object"}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ValidationError{
+			Code:    ErrCodeMalformed,
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		}
+	}
+
+	// Neither decoder has a typed error for unknown fields
+	for _, prefix := range []string{"json: unknown field ", "msgpack: unknown field "} {
+		if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+			field := strings.Trim(strings.TrimPrefix(msg, prefix), `"`)
+			return &ValidationError{Code: ErrCodeUnknownField, Field: field, Message: "unknown field " + field}
+		}
+	}
+
+	return &ValidationError{Code: ErrCodeMalformed, Message: err.Error()}
+}
+
+// jsonTypeName describes a Go type the way it appears in JSON
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLocation(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	ms := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).UnixMilli(), 10)
+	}
+
+	valid := []struct {
+		name string
+		msg  string
+		want Location
+	}{
+		{
+			name: "minimal",
+			msg:  `{"latitude":23.8,"longitude":90.4}`,
+			want: Location{Latitude: 23.8, Longitude: 90.4},
+		},
+		{
+			name: "every field",
+			msg:  `{"deviceId":"truck-1","latitude":-90,"longitude":180,"timestamp":` + ms(-time.Minute) + `,"distance":12.5,"duration":3}`,
+			want: Location{DeviceID: "truck-1", Latitude: -90, Longitude: 180, Timestamp: now.Add(-time.Minute).UnixMilli(), Distance: 12.5, Duration: 3},
+		},
+		{
+			name: "timestamp within the clock skew",
+			msg:  `{"latitude":0,"longitude":0,"timestamp":` + ms(MaxClockSkew-time.Second) + `}`,
+			want: Location{Timestamp: now.Add(MaxClockSkew - time.Second).UnixMilli()},
+		},
+	}
+
+	for _, tt := range valid {
+		t.Run(tt.name, func(t *testing.T) {
+			got, verr := ParseLocation([]byte(tt.msg), now)
+			if verr != nil {
+				t.Fatal(verr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name  string
+		msg   string
+		code  string
+		field string
+	}{
+		{name: "latitude too high", msg: `{"latitude":90.5,"longitude":0}`, code: ErrCodeOutOfRange, field: "latitude"},
+		{name: "latitude too low", msg: `{"latitude":-91,"longitude":0}`, code: ErrCodeOutOfRange, field: "latitude"},
+		{name: "longitude too high", msg: `{"latitude":0,"longitude":180.1}`, code: ErrCodeOutOfRange, field: "longitude"},
+		{name: "longitude too low", msg: `{"latitude":0,"longitude":-200}`, code: ErrCodeOutOfRange, field: "longitude"},
+		{name: "NaN latitude", msg: `{"latitude":NaN,"longitude":0}`, code: ErrCodeMalformed},
+		{name: "infinite longitude", msg: `{"latitude":0,"longitude":1e400}`, code: ErrCodeMalformed, field: "longitude"},
+		{name: "negative distance", msg: `{"latitude":0,"longitude":0,"distance":-1}`, code: ErrCodeOutOfRange, field: "distance"},
+		{name: "missing latitude", msg: `{"longitude":0}`, code: ErrCodeMissingField, field: "latitude"},
+		{name: "missing longitude", msg: `{"latitude":0}`, code: ErrCodeMissingField, field: "longitude"},
+		{name: "string latitude", msg: `{"latitude":"1","longitude":0}`, code: ErrCodeMalformed, field: "latitude"},
+		{name: "fractional timestamp", msg: `{"latitude":0,"longitude":0,"timestamp":1.5}`, code: ErrCodeMalformed, field: "timestamp"},
+		{name: "unknown field", msg: `{"latitude":0,"longitude":0,"speed":3}`, code: ErrCodeUnknownField, field: "speed"},
+		{name: "trailing object", msg: `{"latitude":0,"longitude":0}{"latitude":1,"longitude":1}`, code: ErrCodeMalformed},
+		{name: "trailing garbage", msg: `{"latitude":0,"longitude":0} x`, code: ErrCodeMalformed},
+		{name: "array", msg: `[{"latitude":0,"longitude":0}]`, code: ErrCodeMalformed},
+		{name: "not JSON", msg: `latitude=0`, code: ErrCodeMalformed},
+		{name: "future timestamp", msg: `{"latitude":0,"longitude":0,"timestamp":` + ms(MaxClockSkew+time.Second) + `}`, code: ErrCodeOutOfRange, field: "timestamp"},
+		{name: "stale timestamp", msg: `{"latitude":0,"longitude":0,"timestamp":` + ms(-MaxLocationAge-time.Second) + `}`, code: ErrCodeOutOfRange, field: "timestamp"},
+		{name: "oversized payload", msg: `{"deviceId":"` + strings.Repeat("x", MaxPayloadSize) + `","latitude":0,"longitude":0}`, code: ErrCodeTooLarge},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, verr := ParseLocation([]byte(tt.msg), now)
+			if verr == nil {
+				t.Fatal("ParseLocation succeeded")
+			}
+
+			if verr.Code != tt.code || verr.Field != tt.field {
+				t.Errorf("got %s on %q (%s), want %s on %q", verr.Code, verr.Field, verr.Message, tt.code, tt.field)
+			}
+		})
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if verr := checkRange("latitude", value, -90, 90); verr == nil || verr.Code != ErrCodeOutOfRange {
+			t.Errorf("checkRange(%v) = %v", value, verr)
+		}
+	}
+
+	if verr := checkRange("latitude", 90, -90, 90); verr != nil {
+		t.Errorf("checkRange(90) = %v", verr)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"log"
+	"sort"
+
+	"github.com/nihankhan/locastream/internal/geo"
+	"github.com/valyala/fasthttp"
+)
+
+// TypeViewport messages carry a ViewportEvent
+const TypeViewport = "viewport"
+
+// Viewport event types
+const (
+	ViewportEnter = "enter"
+	ViewportLeave = "leave"
+)
+
+// ViewportEvent tells a client with a viewport that a device moved into or out
+// of it. An enter event is followed by the device's position
+type ViewportEvent struct {
+	Type     string `json:"type"`
+	DeviceID string `json:"deviceId"`
+	Channel  string `json:"channel"`
+}
+
+// requestViewport sets the client's viewport from the bbox query parameter,
+// written as minLon,minLat,maxLon,maxLat, if the request has one
+func (c *Client) requestViewport(ctx *fasthttp.RequestCtx) error {
+	value := ctx.QueryArgs().Peek("bbox")
+	if len(value) == 0 {
+		return nil
+	}
+
+	box, err := geo.ParseBBox(string(value))
+	if err != nil {
+		return err
+	}
+
+	c.viewport = &box
+	c.inView = make(map[string]string)
+
+	return nil
+}
+
+// SetViewport limits the positions the client receives to those inside the
+// box, or lifts the limit when the box is nil. The client is told which
+// devices left and entered its view, and gets the positions of those entering.
+// A zoom level below ClusterMaxZoom switches the client to clusters
+func SetViewport(client *Client, box *geo.BBox, zoom *int) {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	wasClustered := client.clusteredLocked()
+	client.zoom = zoom
+
+	if wasClustered || client.clusteredLocked() {
+		// Switching between clusters and devices starts the client afresh
+		client.viewport = box
+		client.inView = nil
+		if box != nil {
+			client.inView = make(map[string]string)
+		}
+
+		if client.clusteredLocked() {
+			if !wasClustered {
+				client.clusters = nil
+			}
+			client.sendClustersLocked()
+		} else {
+			client.sendSnapshot(client.channels)
+		}
+		return
+	}
+
+	if box == nil {
+		if client.viewport == nil {
+			return
+		}
+
+		client.viewport = nil
+		client.inView = nil
+
+		// The client only knows the devices that were inside its old viewport
+		client.sendSnapshot(client.channels)
+		return
+	}
+
+	// A client without a viewport knows every device on its channels
+	known := client.inView
+	if client.viewport == nil {
+		known = make(map[string]string)
+		for _, env := range envelopesIn(client.channels, nil) {
+			if client.wants(env) {
+				known[env.DeviceID] = env.Channel
+			}
+		}
+	}
+
+	client.viewport = box
+	client.inView = make(map[string]string)
+
+	var entering []*Envelope
+	for _, env := range envelopesIn(client.channels, box) {
+		if !client.wants(env) {
+			continue
+		}
+
+		client.inView[env.DeviceID] = env.Channel
+		if _, ok := known[env.DeviceID]; !ok {
+			entering = append(entering, env)
+		}
+	}
+
+	var leaving []string
+	for deviceID := range known {
+		if _, ok := client.inView[deviceID]; !ok {
+			leaving = append(leaving, deviceID)
+		}
+	}
+	sort.Strings(leaving)
+
+	for _, deviceID := range leaving {
+		client.sendViewportEvent(ViewportLeave, deviceID, known[deviceID])
+	}
+
+	for _, env := range entering {
+		client.sendViewportEvent(ViewportEnter, env.DeviceID, env.Channel)
+		client.send(env)
+	}
+}
+
+// viewLocked reports whether a location envelope is inside the client's
+// viewport, telling the client when its device enters or leaves it.
+// connectionsMutex must be held
+func (c *Client) viewLocked(env *Envelope) bool {
+	_, seen := c.inView[env.DeviceID]
+
+	if !c.viewport.Contains(env.location.Latitude, env.location.Longitude) {
+		if seen {
+			delete(c.inView, env.DeviceID)
+			c.sendViewportEvent(ViewportLeave, env.DeviceID, env.Channel)
+		}
+		return false
+	}
+
+	c.inView[env.DeviceID] = env.Channel
+	if !seen {
+		c.sendViewportEvent(ViewportEnter, env.DeviceID, env.Channel)
+	}
+
+	return true
+}
+
+// sendViewportEvent queues a viewport event for the client
+func (c *Client) sendViewportEvent(eventType, deviceID, channel string) {
+	env, err := NewEnvelope(TypeViewport, deviceID, ViewportEvent{
+		Type:     eventType,
+		DeviceID: deviceID,
+		Channel:  channel,
+	})
+	if err != nil {
+		log.Println("Error building viewport envelope:", err)
+		return
+	}
+
+	env.Channel = channel
+	c.send(env)
+}
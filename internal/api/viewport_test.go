@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+func TestSetViewport(t *testing.T) {
+	forgetDevices(t, "view-a", "view-b", "view-c", "view-d")
+
+	publish := func(deviceID string, lat, lon float64) {
+		if _, err := PublishLocation("viewport-test", Location{DeviceID: deviceID, Latitude: lat, Longitude: lon}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish("view-a", 0, 0)
+	publish("view-b", 0, 10)
+	publish("view-c", 0, 179.5)
+	publish("view-d", 0, -179.5)
+
+	client := &Client{
+		role:     RoleSubscriber,
+		channels: map[string]struct{}{"viewport-test": {}},
+		queue:    newSendQueue(SendQueueSize, Overflow),
+	}
+
+	AddConnection(client)
+	t.Cleanup(func() {
+		RemoveConnection(client)
+	})
+	receivedEnvelopes(t, client)
+
+	// received describes the messages queued for the client, like "enter view-b"
+	// or "location view-b"
+	received := func() []string {
+		var got []string
+		for _, env := range receivedEnvelopes(t, client) {
+			switch env.Type {
+			case TypeViewport:
+				var event ViewportEvent
+				if err := json.Unmarshal(env.Payload, &event); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, event.Type+" "+event.DeviceID)
+			case TypeSnapshot:
+				got = append(got, env.Type)
+			default:
+				got = append(got, env.Type+" "+env.DeviceID)
+			}
+		}
+		return got
+	}
+
+	box := func(minLon, minLat, maxLon, maxLat float64) func() {
+		return func() {
+			SetViewport(client, &geo.BBox{MinLon: minLon, MinLat: minLat, MaxLon: maxLon, MaxLat: maxLat}, nil)
+		}
+	}
+
+	steps := []struct {
+		name string
+		do   func()
+		want []string
+	}{
+		{
+			name: "first viewport",
+			do:   box(-5, -5, 5, 5),
+			want: []string{"leave view-b", "leave view-c", "leave view-d"},
+		},
+		{
+			name: "pan east",
+			do:   box(5, -5, 15, 5),
+			want: []string{"leave view-a", "enter view-b", "location view-b"},
+		},
+		{
+			name: "same viewport",
+			do:   box(5, -5, 15, 5),
+		},
+		{
+			name: "pan across the antimeridian",
+			do:   box(175, -5, -175, 5),
+			want: []string{"leave view-b", "enter view-c", "location view-c", "enter view-d", "location view-d"},
+		},
+		{
+			name: "device leaves across the edge",
+			do:   func() { publish("view-d", 0, -170) },
+			want: []string{"leave view-d"},
+		},
+		{
+			name: "device moves inside across the antimeridian",
+			do:   func() { publish("view-c", 0, -179) },
+			want: []string{"location view-c"},
+		},
+		{
+			name: "device enters across the edge",
+			do:   func() { publish("view-a", 0, 176) },
+			want: []string{"enter view-a", "location view-a"},
+		},
+		{
+			name: "device moves outside",
+			do:   func() { publish("view-b", 0, 11) },
+		},
+		{
+			name: "no viewport",
+			do:   func() { SetViewport(client, nil, nil) },
+			want: []string{"snapshot"},
+		},
+	}
+
+	for _, step := range steps {
+		step.do()
+
+		if got := received(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: received %v, want %v", step.name, got, step.want)
+		}
+	}
+}
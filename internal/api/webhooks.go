@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/webhook"
+	"github.com/valyala/fasthttp"
+)
+
+// Webhooks delivers events to webhook subscriptions. Webhooks are disabled
+// while it is nil
+var Webhooks *webhook.Dispatcher
+
+// ListWebhooks serves the webhook subscriptions the caller may see, without their secrets
+func ListWebhooks(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !webhooksEnabled(ctx) {
+		return
+	}
+
+	list := Webhooks.Registry.List(func(s webhook.Subscription) bool {
+		return canSeeWebhook(principal, s)
+	})
+
+	for i := range list {
+		list[i].Secret = ""
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, list)
+}
+
+// CreateWebhook adds the webhook subscription in the request body. A secret is
+// generated if none is given, and the reply is the only time it is shown. It
+// takes the publisher role or admin rights
+func CreateWebhook(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !webhooksEnabled(ctx) {
+		return
+	}
+
+	if !principal.CanManage() {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to create webhooks")
+		return
+	}
+
+	var s webhook.Subscription
+	dec := json.NewDecoder(bytes.NewReader(ctx.PostBody()))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&s); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, "invalid webhook: "+err.Error())
+		return
+	}
+
+	// Restricted callers only hear about their own channels. Without any, an
+	// empty list would subscribe them to every channel
+	if len(s.Channels) == 0 && !principal.CanAccess(auth.Wildcard) {
+		if len(principal.Channels) == 0 {
+			writeError(ctx, fasthttp.StatusForbidden, "no channels to subscribe to")
+			return
+		}
+		s.Channels = principal.Channels
+	}
+
+	for _, name := range s.Channels {
+		if err := validateChannel(name); err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !principal.CanAccess(name) {
+			writeError(ctx, fasthttp.StatusForbidden, "access to channel "+name+" denied")
+			return
+		}
+	}
+
+	if s.Secret == "" {
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			log.Println("Error generating webhook secret:", err)
+			writeError(ctx, fasthttp.StatusInternalServerError, "could not create webhook")
+			return
+		}
+		s.Secret = secret
+	}
+
+	s, err := Webhooks.Registry.Add(s)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusCreated, s)
+}
+
+// GetWebhook serves the webhook subscription named in the path, without its secret
+func GetWebhook(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !webhooksEnabled(ctx) {
+		return
+	}
+
+	s, ok := visibleWebhook(ctx, principal)
+	if !ok {
+		return
+	}
+
+	s.Secret = ""
+	writeJSON(ctx, fasthttp.StatusOK, s)
+}
+
+// DeleteWebhook removes the webhook subscription named in the path. Like
+// creating one, it takes the publisher role or admin rights
+func DeleteWebhook(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !webhooksEnabled(ctx) {
+		return
+	}
+
+	if !principal.CanManage() {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to remove webhooks")
+		return
+	}
+
+	s, ok := visibleWebhook(ctx, principal)
+	if !ok {
+		return
+	}
+
+	if err := Webhooks.Registry.Remove(s.ID); err != nil {
+		if errors.Is(err, webhook.ErrNotFound) {
+			writeError(ctx, fasthttp.StatusNotFound, "unknown webhook "+s.ID)
+			return
+		}
+
+		log.Println("Error removing webhook:", err)
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not remove webhook")
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// PingWebhook sends a ping event to the webhook subscription named in the
+// path, to check that its endpoint receives and verifies deliveries. Like
+// creating one, it takes the publisher role or admin rights
+func PingWebhook(ctx *fasthttp.RequestCtx) {
+	principal, ok := authenticateRequest(ctx)
+	if !ok || !webhooksEnabled(ctx) {
+		return
+	}
+
+	if !principal.CanManage() {
+		writeError(ctx, fasthttp.StatusForbidden, "not allowed to ping webhooks")
+		return
+	}
+
+	s, ok := visibleWebhook(ctx, principal)
+	if !ok {
+		return
+	}
+
+	event, err := webhook.NewEvent(webhook.EventPing, "", map[string]string{"webhookId": s.ID})
+	if err != nil {
+		log.Println("Error building webhook event:", err)
+		writeError(ctx, fasthttp.StatusInternalServerError, "could not ping webhook")
+		return
+	}
+
+	Webhooks.Deliver(s, event)
+
+	writeJSON(ctx, fasthttp.StatusAccepted, event)
+}
+
+// canSeeWebhook reports whether the principal may see the subscription, which
+// needs access to every channel it covers
+func canSeeWebhook(principal *auth.Principal, s webhook.Subscription) bool {
+	if len(s.Channels) == 0 {
+		return principal.CanAccess(auth.Wildcard)
+	}
+
+	for _, name := range s.Channels {
+		if !principal.CanAccess(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// visibleWebhook looks up the webhook subscription named in the path, replying
+// 404 if it doesn't exist or the caller can't see it
+func visibleWebhook(ctx *fasthttp.RequestCtx, principal *auth.Principal) (webhook.Subscription, bool) {
+	id := fmt.Sprint(ctx.UserValue("id"))
+
+	s, ok := Webhooks.Registry.Get(id)
+	if !ok || !canSeeWebhook(principal, s) {
+		writeError(ctx, fasthttp.StatusNotFound, "unknown webhook "+id)
+		return webhook.Subscription{}, false
+	}
+
+	return s, true
+}
+
+// webhooksEnabled replies 503 if webhooks are disabled
+func webhooksEnabled(ctx *fasthttp.RequestCtx) bool {
+	if Webhooks == nil {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, "webhooks are disabled")
+		return false
+	}
+
+	return true
+}
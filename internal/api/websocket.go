@@ -1,103 +1,527 @@
 package api
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/fasthttp/websocket"
+	"github.com/nihankhan/locastream/internal/auth"
+	"github.com/nihankhan/locastream/internal/geo"
 	"github.com/valyala/fasthttp"
 )
 
 // Location represents the location data
 type Location struct {
+	DeviceID  string  `json:"deviceId"`
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Distance  float64 `json:"distance,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
 }
 
-// Define a mutex to safely access the connections slice from multiple goroutines
+// Role is what a connection is allowed to do
+type Role string
+
+// Connection roles
+const (
+	// RolePublisher connections send location updates for a single device
+	RolePublisher Role = "publisher"
+
+	// RoleSubscriber connections receive updates and cannot publish
+	RoleSubscriber Role = "subscriber"
+)
+
+// Transports a client can be connected over
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// Client represents a connection, its role and, for publishers, the device
+// identity and channel it publishes as
+type Client struct {
+	id          uint64
+	transport   string
+	role        Role
+	deviceID    string
+	connectedAt time.Time
+
+	// WebSocket connection, nil for Server-Sent Events clients, and the wire
+	// format negotiated for it
+	conn   *websocket.Conn
+	format string
+
+	// Channel the publisher posts its updates into
+	channel string
+
+	// Channels the client receives broadcasts from, guarded by connectionsMutex
+	channels map[string]struct{}
+
+	// Devices the client receives updates for, nil for every device
+	devices map[string]struct{}
+
+	// Bounding box the client receives positions in, nil for everywhere, and
+	// the channels of the devices it was told are inside it. Guarded by
+	// connectionsMutex
+	viewport *geo.BBox
+	inView   map[string]string
+
+	// Zoom level of a client that asked for clusters, and the payload of the
+	// last clusters message it was sent. Guarded by connectionsMutex
+	zoom     *int
+	clusters []byte
+
+	// Maximum times per second the client is sent updates, zero for
+	// unlimited, guarded by connectionsMutex
+	maxRate float64
+
+	// Authenticated identity behind the connection
+	principal *auth.Principal
+
+	// Outbound frames, written to the connection by the client's writer goroutine
+	queue *sendQueue
+}
+
+// Define a mutex to safely access the connections slice from multiple goroutines.
+// Code that may raise events while holding it, through emitEventLocked, must
+// release it with unlockConnections, or their webhooks wait for the next caller
+// that does
 var connectionsMutex sync.Mutex
 
 // Slice to hold all WebSocket connections
-var connections []*websocket.Conn
+var connections []*Client
+
+// Last connection ID handed out, guarded by connectionsMutex
+var lastConnectionID uint64
+
+// deviceIDPattern restricts device IDs to characters that are safe in URLs and logs
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// maxFrameSize is the largest frame read from a client before the connection is
+// dropped. Anything above MaxPayloadSize but below this gets an error frame instead
+const maxFrameSize = 64 << 10
+
+// writeWait is how long a single frame write may take before the client is dropped
+const writeWait = 10 * time.Second
 
 var upgrader = websocket.FastHTTPUpgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
-		// Allow all origins
-		return true
-	},
+	CheckOrigin:     originAllowed,
 }
 
+// WebSocket serves connections whose role is negotiated at upgrade time with the
+// role query parameter. Without one the connection is a read-only subscriber
 func WebSocket(ctx *fasthttp.RequestCtx) {
+	role := Role(ctx.QueryArgs().Peek("role"))
+	switch role {
+	case "":
+		role = RoleSubscriber
+	case RolePublisher, RoleSubscriber:
+	default:
+		ctx.Error(fmt.Sprintf("unknown role %q", role), fasthttp.StatusBadRequest)
+		return
+	}
+
+	serveWebSocket(ctx, role)
+}
+
+// PublishWebSocket serves publisher connections
+func PublishWebSocket(ctx *fasthttp.RequestCtx) {
+	serveWebSocket(ctx, RolePublisher)
+}
+
+// SubscribeWebSocket serves read-only subscriber connections
+func SubscribeWebSocket(ctx *fasthttp.RequestCtx) {
+	serveWebSocket(ctx, RoleSubscriber)
+}
+
+func serveWebSocket(ctx *fasthttp.RequestCtx, role Role) {
+	client := &Client{
+		transport: TransportWebSocket,
+		role:      role,
+		channels:  make(map[string]struct{}),
+		queue:     newSendQueue(SendQueueSize, Overflow),
+	}
+
+	if !checkOrigin(ctx) {
+		return
+	}
+
+	// Resolve identity and channels before the upgrade, the request is not usable afterwards
+	principal, ok := authenticateRequest(ctx)
+	if !ok {
+		return
+	}
+	client.principal = principal
+	client.format = requestFormat(ctx)
+
+	names, err := requestChannels(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	for _, name := range names {
+		if !principal.CanAccess(name) {
+			writeError(ctx, fasthttp.StatusForbidden, "access to channel "+name+" denied")
+			return
+		}
+	}
+
+	switch role {
+	case RolePublisher:
+		if !principal.Publish {
+			writeError(ctx, fasthttp.StatusForbidden, "not allowed to publish")
+			return
+		}
+
+		if len(names) > 1 {
+			ctx.Error("publishers post into a single channel", fasthttp.StatusBadRequest)
+			return
+		}
+
+		if isEventChannel(names[0]) {
+			ctx.Error("cannot publish into event channel "+names[0], fasthttp.StatusBadRequest)
+			return
+		}
+
+		deviceID, err := deviceIdentity(ctx, principal.DeviceID)
+		if err != nil {
+			log.Println("WebSocket identity error:", err)
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+
+		if !principal.CanPublishAs(deviceID) {
+			writeError(ctx, fasthttp.StatusForbidden, "not allowed to publish as "+deviceID)
+			return
+		}
+
+		client.deviceID = deviceID
+		client.channel = names[0]
+
+		// Publishers only get the fan-out of their channel when they ask for it
+		if ctx.QueryArgs().GetBool("receive") {
+			client.channels[client.channel] = struct{}{}
+		}
+	case RoleSubscriber:
+		if !principal.Subscribe {
+			writeError(ctx, fasthttp.StatusForbidden, "not allowed to subscribe")
+			return
+		}
+
+		for _, name := range names {
+			client.channels[name] = struct{}{}
+		}
+	}
+
+	if err := client.requestViewport(ctx); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if err := client.requestZoom(ctx); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if err := client.requestRate(ctx); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
 	// Upgrade the connection to WebSocket
-	err := upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
+	err = upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
 		defer conn.Close()
 
+		client.conn = conn
+		client.connectedAt = time.Now().UTC()
+
 		// Add the new WebSocket connection
-		AddConnection(conn)
-		defer RemoveConnection(conn)
+		AddConnection(client)
+		defer RemoveConnection(client)
+
+		// Frames are written by a dedicated goroutine so slow clients never block broadcasts
+		go client.writeLoop()
+		defer client.queue.close()
+
+		conn.SetReadLimit(maxFrameSize)
 
 		for {
-			_, msg, err := conn.ReadMessage()
+			messageType, msg, err := conn.ReadMessage()
 			if err != nil {
 				log.Println("WebSocket read error:", err)
 				break
 			}
 
-			// Parse the incoming message as location data
-			var location Location
-			err = json.Unmarshal(msg, &location)
-			if err != nil {
-				log.Println("Error parsing location data:", err)
+			if client.role != RolePublisher {
+				client.handleControl(client.message(messageType, msg))
 				continue
 			}
 
-			// Broadcast the location data to all connected clients
-			BroadcastMessage(msg)
+			client.publish(client.message(messageType, msg))
 		}
 	})
 	if err != nil {
+		// The upgrader has already replied with the matching error status
 		log.Println("WebSocket upgrade error:", err)
-		ctx.Error("WebSocket upgrade failed", fasthttp.StatusInternalServerError)
 	}
 }
 
-// BroadcastMessage broadcasts the message to all connected clients
-func BroadcastMessage(msg []byte) {
-	connectionsMutex.Lock()
-	defer connectionsMutex.Unlock()
+// publish validates a location update sent by the client and broadcasts it
+func (c *Client) publish(msg message) {
+	// Parse and validate the incoming message as location data
+	location, verr := parseLocation(msg, time.Now())
+	if verr != nil {
+		log.Printf("Rejected location from %s: %v", c.deviceID, verr)
+		c.sendError(verr)
+		return
+	}
 
-	// Iterate over all connected clients and send the message
-	for _, conn := range connections {
-		err := conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			// Handle write error (e.g., connection closed)
-			fmt.Println("Error writing message:", err)
+	// Reject updates that claim to come from another device
+	if location.DeviceID != "" && location.DeviceID != c.deviceID {
+		log.Printf("Rejected location from %s claiming device ID %s", c.deviceID, location.DeviceID)
+		c.sendError(&ValidationError{
+			Code:    ErrCodeDeviceMismatch,
+			Field:   "deviceId",
+			Message: "deviceId does not match the connection's device ID",
+		})
+		return
+	}
+	location.DeviceID = c.deviceID
+
+	// Broadcast the location data to the members of the publisher's channel
+	if _, err := PublishLocation(c.channel, location); err != nil {
+		log.Println("Error publishing location:", err)
+	}
+}
+
+// deviceIdentity returns the device ID requested by the connecting client through
+// the deviceId query parameter or the X-Device-ID header. If the client did not
+// ask for one it gets the fallback, or a generated ID if that is empty too
+func deviceIdentity(ctx *fasthttp.RequestCtx, fallback string) (string, error) {
+	id := string(ctx.QueryArgs().Peek("deviceId"))
+	if id == "" {
+		id = string(ctx.Request.Header.Peek("X-Device-ID"))
+	}
+
+	if id == "" {
+		id = fallback
+	}
+
+	if id == "" {
+		return newDeviceID()
+	}
+
+	if !deviceIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid device ID %q", id)
+	}
+
+	return id, nil
+}
+
+// newDeviceID generates a random device ID for anonymous publishers
+func newDeviceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "anon-" + hex.EncodeToString(b), nil
+}
+
+// clustersKey is the queue key of clusters frames. It is not a valid device
+// ID, so it can't be mistaken for a position
+const clustersKey = "/clusters"
+
+// newFrame wraps an envelope encoded in a wire format into a frame that can be
+// queued for clients. Position updates are keyed by device ID, and clusters
+// by clustersKey, so the overflow policy can coalesce them
+func newFrame(env *Envelope, data []byte) outbound {
+	frame := outbound{id: env.ID, typ: env.Type, data: data}
+	switch env.Type {
+	case TypeLocation:
+		frame.key = env.DeviceID
+	case TypeClusters:
+		frame.key = clustersKey
+	}
+
+	return frame
+}
+
+// send encodes the envelope in the client's wire format and queues it without
+// blocking
+func (c *Client) send(env *Envelope) {
+	frame, err := newFrameSet(env).frame(c.format)
+	if err != nil {
+		log.Printf("Error encoding %s envelope: %v", env.Type, err)
+		return
+	}
+
+	c.queue.push(frame)
+}
+
+// wants reports whether the client receives the envelope, connectionsMutex must be held
+func (c *Client) wants(env *Envelope) bool {
+	if _, ok := c.channels[env.Channel]; !ok {
+		return false
+	}
+
+	if c.devices == nil || env.DeviceID == "" {
+		return true
+	}
+
+	_, ok := c.devices[env.DeviceID]
+
+	return ok
+}
+
+// writeLoop writes queued frames to the connection until the queue is closed
+func (c *Client) writeLoop() {
+	for {
+		items, ok := c.queue.pop(nil)
+		if !ok {
+			break
 		}
+
+		for _, item := range items {
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			if err := c.conn.WriteMessage(c.messageType(), item.data); err != nil {
+				// Handle write error (e.g., connection closed)
+				log.Printf("Error writing message to client %d: %v", c.id, err)
+				c.queue.close()
+				c.conn.Close()
+				return
+			}
+		}
+	}
+
+	if c.queue.hasOverflowed() {
+		log.Printf("Disconnecting slow client %d: send queue overflowed", c.id)
+
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "send queue overflow")
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	}
+
+	// Unblocks the reader if the queue was closed from the broadcast side
+	c.conn.Close()
+}
+
+// sendError sends an error frame describing a rejected message back to the client
+func (c *Client) sendError(verr *ValidationError) {
+	env, err := NewEnvelope(TypeError, c.deviceID, verr)
+	if err != nil {
+		log.Println("Error building error envelope:", err)
+		return
 	}
+
+	c.send(env)
 }
 
-// AddConnection adds a new WebSocket connection to the list of connections
-func AddConnection(conn *websocket.Conn) {
+// BroadcastMessage broadcasts the envelope to the members of its channel. It
+// only queues the frame for each client and never waits on slow connections
+func BroadcastMessage(env *Envelope) {
 	connectionsMutex.Lock()
 	defer connectionsMutex.Unlock()
 
-	connections = append(connections, conn)
+	broadcastLocked(env)
+}
+
+// broadcastLocked numbers the envelope, keeps it for resuming clients and
+// queues it for the members of its channel, connectionsMutex must be held
+func broadcastLocked(env *Envelope) {
+	recordEvent(env)
+
+	// Each wire format is encoded once, if a member uses it, and shared by
+	// the members using it
+	frames := newFrameSet(env)
+
+	// Iterate over the channel's members and queue the message
+	for client := range channels[env.Channel] {
+		client.deliverLocked(env, frames)
+	}
 }
 
-// RemoveConnection removes a WebSocket connection from the list of connections
-func RemoveConnection(conn *websocket.Conn) {
+// deliverLocked queues the frame of a broadcast envelope if the client wants
+// it, keeping clients with a viewport to the positions inside it and holding
+// positions back from clients that get clusters.
+// connectionsMutex must be held
+func (c *Client) deliverLocked(env *Envelope, frames *frameSet) {
+	if !c.wants(env) {
+		return
+	}
+
+	// Clustering clients get positions in their next clusters instead
+	if c.clusteredLocked() && env.location != nil {
+		return
+	}
+
+	if c.viewport != nil && env.location != nil && !c.viewLocked(env) {
+		return
+	}
+
+	frame, err := frames.frame(c.format)
+	if err != nil {
+		log.Printf("Error encoding envelope as %s: %v", c.format, err)
+		return
+	}
+
+	c.queue.push(frame)
+}
+
+// AddConnection adds a new connection to the list of connections
+func AddConnection(client *Client) {
 	connectionsMutex.Lock()
 	defer connectionsMutex.Unlock()
 
+	registerLocked(client)
+
+	// New receivers start with the latest known positions before the live stream
+	if len(client.channels) > 0 {
+		client.sendSnapshot(client.channels)
+	}
+}
+
+// registerLocked assigns the client its ID and adds it to the connections and
+// its channels, connectionsMutex must be held
+func registerLocked(client *Client) {
+	lastConnectionID++
+	client.id = lastConnectionID
+
+	connections = append(connections, client)
+
+	for name := range client.channels {
+		joinChannel(client, name)
+	}
+}
+
+// RemoveConnection removes a connection from the list of connections
+func RemoveConnection(client *Client) {
+	connectionsMutex.Lock()
+	defer unlockConnections()
+
+	for name := range client.channels {
+		leaveChannel(client, name)
+	}
+
 	// Find and remove the connection from the slice
 	for i, c := range connections {
-		if c == conn {
+		if c == client {
 			connections = append(connections[:i], connections[i+1:]...)
 			break
 		}
 	}
+
+	// A device whose last publisher went away is offline until it publishes again
+	if client.role == RolePublisher && !publishingLocked(client.deviceID) {
+		markOfflineLocked(client.deviceID, OfflineDisconnected, time.Now().UTC())
+	}
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// receivedEnvelopes drains the frames queued for a client and decodes them
+func receivedEnvelopes(t *testing.T, c *Client) []Envelope {
+	t.Helper()
+
+	items, _ := c.queue.pop(time.After(10 * time.Millisecond))
+
+	envs := make([]Envelope, 0, len(items))
+	for _, item := range items {
+		var env Envelope
+		if err := json.Unmarshal(item.data, &env); err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+
+	return envs
+}
+
+func TestPublishDeviceID(t *testing.T) {
+	forgetDevices(t, "spoof-a", "spoof-b")
+
+	publisher := &Client{
+		role:     RolePublisher,
+		deviceID: "spoof-a",
+		channel:  "spoof-test",
+		channels: map[string]struct{}{},
+		queue:    newSendQueue(SendQueueSize, Overflow),
+	}
+	subscriber := &Client{
+		role:     RoleSubscriber,
+		channels: map[string]struct{}{"spoof-test": {}},
+		queue:    newSendQueue(SendQueueSize, Overflow),
+	}
+
+	AddConnection(publisher)
+	AddConnection(subscriber)
+	t.Cleanup(func() {
+		RemoveConnection(publisher)
+		RemoveConnection(subscriber)
+	})
+	receivedEnvelopes(t, subscriber)
+
+	tests := []struct {
+		name string
+		msg  string
+
+		// Error code sent to the publisher, empty if the update is broadcast
+		code string
+	}{
+		{name: "another device", msg: `{"deviceId":"spoof-b","latitude":1,"longitude":2}`, code: ErrCodeDeviceMismatch},
+		{name: "own device", msg: `{"deviceId":"spoof-a","latitude":1,"longitude":2}`},
+		{name: "no device", msg: `{"latitude":1,"longitude":2}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher.publish(message{data: []byte(tt.msg)})
+
+			var codes []string
+			for _, env := range receivedEnvelopes(t, publisher) {
+				var verr ValidationError
+				if err := json.Unmarshal(env.Payload, &verr); err != nil {
+					t.Fatal(err)
+				}
+				codes = append(codes, verr.Code)
+			}
+
+			var broadcast []Envelope
+			for _, env := range receivedEnvelopes(t, subscriber) {
+				if env.Type == TypeLocation {
+					broadcast = append(broadcast, env)
+				}
+			}
+
+			if tt.code != "" {
+				if len(codes) != 1 || codes[0] != tt.code || len(broadcast) != 0 {
+					t.Errorf("publisher got errors %v and %d updates were broadcast, want %s and none", codes, len(broadcast), tt.code)
+				}
+				return
+			}
+
+			if len(codes) != 0 || len(broadcast) != 1 {
+				t.Fatalf("publisher got errors %v and %d updates were broadcast, want one update", codes, len(broadcast))
+			}
+
+			var location Location
+			if err := json.Unmarshal(broadcast[0].Payload, &location); err != nil {
+				t.Fatal(err)
+			}
+			if broadcast[0].DeviceID != "spoof-a" || location.DeviceID != "spoof-a" {
+				t.Errorf("broadcast as %q with payload device %q, want spoof-a", broadcast[0].DeviceID, location.DeviceID)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+)
+
+// Wildcard grants access to every channel
+const Wildcard = "*"
+
+// EventChannelSuffix names the channel events about a channel's devices are
+// broadcast on, e.g. fleet-a:events
+const EventChannelSuffix = ":events"
+
+// ErrInvalidToken is returned by verifiers for tokens they don't accept
+var ErrInvalidToken = errors.New("invalid token")
+
+// Principal is the authenticated identity behind a connection or request and
+// what it is allowed to do
+type Principal struct {
+	// Subject identifies the principal, e.g. a user, service or device name
+	Subject string `json:"subject"`
+
+	// DeviceID, if set, is the only device the principal may publish as
+	DeviceID string `json:"deviceId,omitempty"`
+
+	// Channels the principal may publish into or subscribe to, Wildcard for all
+	Channels []string `json:"channels"`
+
+	// Publish and Subscribe grant the publisher and subscriber roles
+	Publish   bool `json:"publish"`
+	Subscribe bool `json:"subscribe"`
+
+	// Admin grants managing geofences and webhooks without publishing
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Anonymous returns the principal used when authentication is disabled, which
+// may do anything
+func Anonymous() *Principal {
+	return &Principal{
+		Subject:   "anonymous",
+		Channels:  []string{Wildcard},
+		Publish:   true,
+		Subscribe: true,
+		Admin:     true,
+	}
+}
+
+// CanAccess reports whether the principal may use the channel. Access to a
+// channel covers its event channel, e.g. fleet-a:events, but no other channel
+// named after it
+func (p *Principal) CanAccess(channel string) bool {
+	for _, c := range p.Channels {
+		if c == Wildcard || c == channel || c+EventChannelSuffix == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanPublishAs reports whether the principal may publish as the device
+func (p *Principal) CanPublishAs(deviceID string) bool {
+	return p.Publish && (p.DeviceID == "" || p.DeviceID == deviceID)
+}
+
+// CanManage reports whether the principal may create geofences and webhooks
+// on its channels, which takes the publisher role or admin rights
+func (p *Principal) CanManage() bool {
+	return p.Publish || p.Admin
+}
+
+// Verifier validates a bearer token and returns the principal it grants
+type Verifier interface {
+	Verify(token string) (*Principal, error)
+}
+
+// Chain is a Verifier that accepts a token if any of its verifiers does
+type Chain []Verifier
+
+// Verify tries each verifier in order and returns the first principal granted
+func (c Chain) Verify(token string) (*Principal, error) {
+	for _, v := range c {
+		principal, err := v.Verify(token)
+		if err == nil {
+			return principal, nil
+		}
+
+		if !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+	}
+
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,31 @@
+package auth
+
+import "testing"
+
+func TestCanAccess(t *testing.T) {
+	tests := []struct {
+		channels []string
+		channel  string
+		want     bool
+	}{
+		{channels: []string{"fleet"}, channel: "fleet", want: true},
+		{channels: []string{"fleet"}, channel: "fleet:events", want: true},
+		{channels: []string{"fleet"}, channel: "fleet:eu"},
+		{channels: []string{"fleet"}, channel: "fleet:eu:events"},
+		{channels: []string{"fleet"}, channel: "fleet:events:events"},
+		{channels: []string{"fleet"}, channel: "fleet-b"},
+		{channels: []string{"fleet"}, channel: "fleetx:events"},
+		{channels: []string{"fleet:eu"}, channel: "fleet:eu:events", want: true},
+		{channels: []string{"fleet:eu"}, channel: "fleet"},
+		{channels: []string{"fleet", "fleet:eu"}, channel: "fleet:eu", want: true},
+		{channels: []string{Wildcard}, channel: "fleet:eu", want: true},
+		{channels: nil, channel: "fleet"},
+	}
+
+	for _, tt := range tests {
+		p := &Principal{Channels: tt.channels}
+		if got := p.CanAccess(tt.channel); got != tt.want {
+			t.Errorf("%v CanAccess(%q) = %v, want %v", tt.channels, tt.channel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// Roles listed in the roles claim of a JWT
+const (
+	RolePublish   = "publish"
+	RoleSubscribe = "subscribe"
+	RoleAdmin     = "admin"
+)
+
+// JWTVerifier is a Verifier for HMAC-signed JSON Web Tokens (HS256, HS384 and
+// HS512), checked locally against a shared secret
+type JWTVerifier struct {
+	Secret []byte
+
+	// Issuer, if set, must match the iss claim
+	Issuer string
+
+	// Leeway allowed when checking exp and nbf against the clock
+	Leeway time.Duration
+}
+
+// jwtHeader is the part of the JOSE header the verifier looks at
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// jwtClaims are the claims the verifier understands
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	DeviceID  string   `json:"deviceId"`
+	Channels  []string `json:"channels"`
+	Roles     []string `json:"roles"`
+}
+
+// Verify checks the token's signature and time claims and returns the
+// principal described by its claims
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	newHash, err := hashFor(header.Alg)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	mac := hmac.New(newHash, v.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt != nil && now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.Leeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+
+	if claims.NotBefore != nil && now.Add(v.Leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	principal := &Principal{
+		Subject:  claims.Subject,
+		DeviceID: claims.DeviceID,
+		Channels: claims.Channels,
+	}
+
+	for _, role := range claims.Roles {
+		switch role {
+		case RolePublish:
+			principal.Publish = true
+		case RoleSubscribe:
+			principal.Subscribe = true
+		case RoleAdmin:
+			principal.Admin = true
+		}
+	}
+
+	return principal, nil
+}
+
+// hashFor returns the hash function of a JWS HMAC algorithm
+func hashFor(alg string) (func() hash.Hash, error) {
+	switch alg {
+	case "HS256":
+		return sha256.New, nil
+	case "HS384":
+		return sha512.New384, nil
+	case "HS512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// signToken builds a JWT with the header algorithm alg, signed with the hash of signAlg
+func signToken(t *testing.T, alg, signAlg string, secret []byte, claims map[string]interface{}) string {
+	t.Helper()
+
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	unsigned := segment(map[string]string{"alg": alg, "typ": "JWT"}) + "." + segment(claims)
+
+	newHash, err := hashFor(signAlg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(newHash, secret)
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerifier(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now().Unix()
+
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":      "truck-42",
+			"deviceId": "truck-42",
+			"channels": []string{"fleet-a"},
+			"roles":    []string{"publish"},
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		issuer string
+		valid  bool
+	}{
+		{name: "HS256", token: signToken(t, "HS256", "HS256", secret, claims(nil)), valid: true},
+		{name: "HS384", token: signToken(t, "HS384", "HS384", secret, claims(nil)), valid: true},
+		{name: "HS512", token: signToken(t, "HS512", "HS512", secret, claims(nil)), valid: true},
+		{name: "wrong secret", token: signToken(t, "HS256", "HS256", []byte("other"), claims(nil))},
+		{name: "algorithm mismatch", token: signToken(t, "HS512", "HS256", secret, claims(nil))},
+		{name: "unsigned algorithm", token: signToken(t, "none", "HS256", secret, claims(nil))},
+		{name: "malformed", token: "not-a-token"},
+		{name: "not expired", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"exp": now + 60})), valid: true},
+		{name: "expired", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"exp": now - 60}))},
+		{name: "not valid yet", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"nbf": now + 60}))},
+		{name: "missing subject", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"sub": ""}))},
+		{name: "issuer", issuer: "locastream", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"iss": "locastream"})), valid: true},
+		{name: "wrong issuer", issuer: "locastream", token: signToken(t, "HS256", "HS256", secret, claims(map[string]interface{}{"iss": "other"}))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &JWTVerifier{Secret: secret, Issuer: tt.issuer}
+
+			principal, err := v.Verify(tt.token)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if principal.Subject != "truck-42" || principal.DeviceID != "truck-42" || !principal.Publish || principal.Subscribe {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+
+			if !principal.CanAccess("fleet-a") || principal.CanAccess("fleet-b") {
+				t.Errorf("principal channels %v", principal.Channels)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierLeeway(t *testing.T) {
+	secret := []byte("test-secret")
+	token := signToken(t, "HS256", "HS256", secret, map[string]interface{}{
+		"sub": "dashboard",
+		"exp": time.Now().Add(-30 * time.Second).Unix(),
+	})
+
+	if _, err := (&JWTVerifier{Secret: secret}).Verify(token); err == nil {
+		t.Error("expired token accepted without leeway")
+	}
+
+	if _, err := (&JWTVerifier{Secret: secret, Leeway: time.Minute}).Verify(token); err != nil {
+		t.Errorf("token within leeway rejected: %v", err)
+	}
+}
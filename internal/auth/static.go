@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// APIKey is an entry of a static API key file
+type APIKey struct {
+	Key string `json:"key"`
+	Principal
+}
+
+// StaticKeys is a Verifier for a fixed set of API keys
+type StaticKeys struct {
+	// Keys are indexed by their SHA-256 so lookups don't compare secrets directly
+	keys map[[sha256.Size]byte]*Principal
+}
+
+// NewStaticKeys returns a verifier accepting the given API keys
+func NewStaticKeys(keys []APIKey) (*StaticKeys, error) {
+	s := &StaticKeys{keys: make(map[[sha256.Size]byte]*Principal)}
+
+	for i := range keys {
+		if keys[i].Key == "" {
+			return nil, fmt.Errorf("api key %d has no key", i)
+		}
+
+		principal := keys[i].Principal
+		s.keys[sha256.Sum256([]byte(keys[i].Key))] = &principal
+	}
+
+	return s, nil
+}
+
+// LoadStaticKeys reads API keys from a JSON file holding an array of APIKey
+func LoadStaticKeys(path string) (*StaticKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("error parsing api keys file %s: %v", path, err)
+	}
+
+	return NewStaticKeys(keys)
+}
+
+// Verify returns the principal of the API key
+func (s *StaticKeys) Verify(token string) (*Principal, error) {
+	principal, ok := s.keys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	p := *principal
+
+	return &p, nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds the server settings. Every setting can be given as a command
+// line flag or, as a fallback, an environment variable
+type Config struct {
+	// Addr is the address the server listens on
+	Addr string
+
+	// SendQueueSize is the number of frames buffered per connection
+	SendQueueSize int
+
+	// Overflow is the policy applied when a connection's send queue is full:
+	// drop-oldest, latest or disconnect
+	Overflow string
+
+	// APIKeysFile is a JSON file of static API keys, authentication is enabled
+	// when it or JWTSecret is set
+	APIKeysFile string
+
+	// JWTSecret is the shared secret HMAC-signed JWTs are checked against
+	JWTSecret string
+
+	// JWTIssuer, if set, must match the iss claim of JWTs
+	JWTIssuer string
+
+	// AllowedOrigins lists the browser origins that may open WebSocket
+	// connections: "*", "same-origin", exact origins or wildcard subdomains
+	AllowedOrigins []string
+
+	// History is the location history backend: none, file or bolt
+	History string
+
+	// DataDir is where the history backend keeps its files
+	DataDir string
+}
+
+// Load parses the command line flags into a Config, using the environment and
+// then the built-in defaults for anything not given on the command line
+func Load() *Config {
+	cfg := &Config{}
+
+	flag.StringVar(&cfg.Addr, "addr", envString("LOCASTREAM_ADDR", ":8080"), "address to listen on")
+	flag.IntVar(&cfg.SendQueueSize, "send-queue-size", envInt("LOCASTREAM_SEND_QUEUE_SIZE", 256), "frames buffered per connection")
+	flag.StringVar(&cfg.Overflow, "overflow", envString("LOCASTREAM_OVERFLOW", "drop-oldest"), "send queue overflow policy: drop-oldest, latest or disconnect")
+
+	flag.StringVar(&cfg.APIKeysFile, "api-keys", envString("LOCASTREAM_API_KEYS", ""), "JSON file of static API keys")
+	flag.StringVar(&cfg.JWTSecret, "jwt-secret", envString("LOCASTREAM_JWT_SECRET", ""), "shared secret for HMAC-signed JWTs")
+	flag.StringVar(&cfg.JWTIssuer, "jwt-issuer", envString("LOCASTREAM_JWT_ISSUER", ""), "required issuer of JWTs")
+
+	origins := flag.String("allowed-origins", envString("LOCASTREAM_ALLOWED_ORIGINS", "same-origin"), "comma-separated origins allowed to open WebSocket connections")
+
+	flag.StringVar(&cfg.History, "history", envString("LOCASTREAM_HISTORY", "file"), "location history backend: none, file or bolt")
+	flag.StringVar(&cfg.DataDir, "data-dir", envString("LOCASTREAM_DATA_DIR", "data"), "directory for location history")
+
+	flag.Parse()
+
+	cfg.AllowedOrigins = splitList(*origins)
+
+	return cfg
+}
+
+// envString returns the environment variable or the fallback if it is unset
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// envInt returns the environment variable as an integer or the fallback if it
+// is unset or not a number
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/store"
+)
+
+// Export formats
+const (
+	FormatGPX     = "gpx"
+	FormatKML     = "kml"
+	FormatGeoJSON = "geojson"
+)
+
+// Track is the recorded path of a device, oldest point first
+type Track struct {
+	DeviceID string
+	Points   []store.Record
+}
+
+// Start returns the time of the first point, or the zero time for an empty track
+func (t Track) Start() time.Time {
+	if len(t.Points) == 0 {
+		return time.Time{}
+	}
+
+	return t.Points[0].Time
+}
+
+// End returns the time of the last point, or the zero time for an empty track
+func (t Track) End() time.Time {
+	if len(t.Points) == 0 {
+		return time.Time{}
+	}
+
+	return t.Points[len(t.Points)-1].Time
+}
+
+// Write encodes the track in the named format
+func Write(w io.Writer, format string, track Track) error {
+	switch format {
+	case FormatGPX:
+		return WriteGPX(w, track)
+	case FormatKML:
+		return WriteKML(w, track)
+	case FormatGeoJSON:
+		return WriteGeoJSON(w, track)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// ContentType returns the MIME type of the named format
+func ContentType(format string) string {
+	switch format {
+	case FormatGPX:
+		return "application/gpx+xml"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Known reports whether the format can be exported
+func Known(format string) bool {
+	switch format {
+	case FormatGPX, FormatKML, FormatGeoJSON:
+		return true
+	default:
+		return false
+	}
+}
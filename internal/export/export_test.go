@@ -0,0 +1,223 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/store"
+)
+
+var start = time.Date(2024, 5, 1, 10, 0, 0, 0, time.FixedZone("BST", 6*60*60))
+
+// testTrack returns a track of up to three points one minute apart
+func testTrack(n int) Track {
+	coords := [][2]float64{{23.8, 90.4}, {23.801, 90.402}, {23.802, 90.404}}
+
+	track := Track{DeviceID: "truck-42"}
+	for i := 0; i < n; i++ {
+		track.Points = append(track.Points, store.Record{
+			DeviceID:  "truck-42",
+			Channel:   "fleet-a",
+			Seq:       uint64(i + 1),
+			Latitude:  coords[i][0],
+			Longitude: coords[i][1],
+			Time:      start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	return track
+}
+
+func TestWriteGPX(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("%d points", n), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteGPX(&buf, testTrack(n)); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(buf.String(), xml.Header) {
+				t.Error("missing XML declaration")
+			}
+
+			var doc gpxDocument
+			if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			if doc.Version != "1.1" || doc.XMLNS != "http://www.topografix.com/GPX/1/1" {
+				t.Errorf("got version %q namespace %q", doc.Version, doc.XMLNS)
+			}
+
+			if len(doc.Tracks) != 1 || len(doc.Tracks[0].Segments) != 1 {
+				t.Fatalf("got %d tracks, want one with one segment", len(doc.Tracks))
+			}
+
+			points := doc.Tracks[0].Segments[0].Points
+			if len(points) != n {
+				t.Fatalf("got %d points, want %d", len(points), n)
+			}
+
+			for i, p := range points {
+				want := testTrack(n).Points[i]
+				if p.Lat != want.Latitude || p.Lon != want.Longitude || !p.Time.Equal(want.Time) || p.Time.Location() != time.UTC {
+					t.Errorf("point %d = %+v, want %v,%v at %v UTC", i, p, want.Latitude, want.Longitude, want.Time)
+				}
+			}
+
+			if n == 0 && doc.Metadata.Time != nil {
+				t.Error("empty track has a metadata time")
+			}
+			if n > 0 && (doc.Metadata.Time == nil || !doc.Metadata.Time.Equal(start)) {
+				t.Errorf("metadata time %v, want %v", doc.Metadata.Time, start)
+			}
+		})
+	}
+}
+
+func TestWriteKML(t *testing.T) {
+	tests := []struct {
+		name       string
+		points     int
+		lineString string
+		point      string
+	}{
+		{name: "empty", points: 0},
+		{name: "single fix", points: 1, point: "90.4,23.8"},
+		{name: "path", points: 3, lineString: "90.4,23.8 90.402,23.801 90.404,23.802"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteKML(&buf, testTrack(tt.points)); err != nil {
+				t.Fatal(err)
+			}
+
+			var doc kmlDocument
+			if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			placemark := doc.Document.Placemark
+			if placemark.Name != "truck-42" {
+				t.Errorf("placemark name %q", placemark.Name)
+			}
+
+			var lineString, point string
+			if placemark.LineString != nil {
+				lineString = placemark.LineString.Coordinates
+			}
+			if placemark.Point != nil {
+				point = placemark.Point.Coordinates
+			}
+
+			if lineString != tt.lineString || point != tt.point {
+				t.Errorf("got LineString %q Point %q, want %q and %q", lineString, point, tt.lineString, tt.point)
+			}
+
+			if tt.points == 0 {
+				if placemark.TimeSpan != nil {
+					t.Error("empty track has a time span")
+				}
+				return
+			}
+
+			end := start.Add(time.Duration(tt.points-1) * time.Minute)
+			if placemark.TimeSpan == nil || placemark.TimeSpan.Begin != start.UTC().Format(time.RFC3339) || placemark.TimeSpan.End != end.UTC().Format(time.RFC3339) {
+				t.Errorf("time span %+v", placemark.TimeSpan)
+			}
+		})
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   int
+		geometry string
+	}{
+		{name: "empty", points: 0},
+		{name: "single fix", points: 1, geometry: "Point"},
+		{name: "path", points: 3, geometry: "LineString"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteGeoJSON(&buf, testTrack(tt.points)); err != nil {
+				t.Fatal(err)
+			}
+
+			var collection struct {
+				Type     string
+				Features []struct {
+					Geometry struct {
+						Type        string
+						Coordinates json.RawMessage
+					}
+					Properties map[string]interface{}
+				}
+			}
+			if err := json.Unmarshal(buf.Bytes(), &collection); err != nil {
+				t.Fatal(err)
+			}
+
+			if collection.Type != "FeatureCollection" {
+				t.Errorf("type %q", collection.Type)
+			}
+
+			if tt.points == 0 {
+				if len(collection.Features) != 0 {
+					t.Errorf("empty track has %d features", len(collection.Features))
+				}
+				return
+			}
+
+			// The path followed by a Point per fix
+			if len(collection.Features) != tt.points+1 {
+				t.Fatalf("got %d features, want %d", len(collection.Features), tt.points+1)
+			}
+
+			path := collection.Features[0]
+			if path.Geometry.Type != tt.geometry || path.Properties["deviceId"] != "truck-42" {
+				t.Errorf("path is a %s of %v", path.Geometry.Type, path.Properties["deviceId"])
+			}
+
+			if times, _ := path.Properties["coordTimes"].([]interface{}); len(times) != tt.points {
+				t.Errorf("got %d coordTimes, want %d", len(times), tt.points)
+			}
+
+			for i, f := range collection.Features[1:] {
+				var coords []float64
+				if err := json.Unmarshal(f.Geometry.Coordinates, &coords); err != nil {
+					t.Fatal(err)
+				}
+
+				want := testTrack(tt.points).Points[i]
+				if f.Geometry.Type != "Point" || len(coords) != 2 || coords[0] != want.Longitude || coords[1] != want.Latitude {
+					t.Errorf("fix %d is a %s at %v", i, f.Geometry.Type, coords)
+				}
+
+				if f.Properties["seq"] != float64(want.Seq) || f.Properties["channel"] != "fleet-a" {
+					t.Errorf("fix %d properties %v", i, f.Properties)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, "csv", testTrack(2)); err == nil {
+		t.Error("Write accepted an unknown format")
+	}
+
+	if Known("csv") || !Known(FormatGPX) || !Known(FormatKML) || !Known(FormatGeoJSON) {
+		t.Error("Known disagrees with Write")
+	}
+}
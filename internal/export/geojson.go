@@ -0,0 +1,91 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Geometry is a GeoJSON geometry
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// Feature is a GeoJSON feature. A nil Geometry encodes as null
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// TrackFeature returns the track as a feature, a LineString when it has at
+// least two points, with the time of every point in coordTimes
+func TrackFeature(track Track) Feature {
+	coords := make([][]float64, len(track.Points))
+	times := make([]time.Time, len(track.Points))
+
+	for i, p := range track.Points {
+		coords[i] = []float64{p.Longitude, p.Latitude}
+		times[i] = p.Time
+	}
+
+	feature := Feature{
+		Type: "Feature",
+		Properties: map[string]interface{}{
+			"deviceId":   track.DeviceID,
+			"coordTimes": times,
+		},
+	}
+
+	switch len(coords) {
+	case 0:
+	case 1:
+		feature.Geometry = &Geometry{Type: "Point", Coordinates: coords[0]}
+	default:
+		feature.Geometry = &Geometry{Type: "LineString", Coordinates: coords}
+	}
+
+	return feature
+}
+
+// TrackCollection returns the track as a feature collection holding the path
+// followed by a Point feature for every recorded fix
+func TrackCollection(track Track) FeatureCollection {
+	collection := FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]Feature, 0, len(track.Points)+1),
+	}
+
+	if len(track.Points) == 0 {
+		return collection
+	}
+
+	collection.Features = append(collection.Features, TrackFeature(track))
+
+	for _, p := range track.Points {
+		collection.Features = append(collection.Features, Feature{
+			Type:     "Feature",
+			Geometry: &Geometry{Type: "Point", Coordinates: []float64{p.Longitude, p.Latitude}},
+			Properties: map[string]interface{}{
+				"deviceId": p.DeviceID,
+				"channel":  p.Channel,
+				"seq":      p.Seq,
+				"time":     p.Time,
+			},
+		})
+	}
+
+	return collection
+}
+
+// WriteGeoJSON encodes the track as a GeoJSON feature collection
+func WriteGeoJSON(w io.Writer, track Track) error {
+	return json.NewEncoder(w).Encode(TrackCollection(track))
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// GPX 1.1 document, limited to the elements needed for a single track
+type gpxDocument struct {
+	XMLName  xml.Name     `xml:"gpx"`
+	Version  string       `xml:"version,attr"`
+	Creator  string       `xml:"creator,attr"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Metadata *gpxMetadata `xml:"metadata,omitempty"`
+	Tracks   []gpxTrack   `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string     `xml:"name"`
+	Time *time.Time `xml:"time,omitempty"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Time time.Time `xml:"time"`
+}
+
+// WriteGPX encodes the track as a GPX 1.1 document with one trk holding one
+// trkseg, each trkpt stamped with the time it was received
+func WriteGPX(w io.Writer, track Track) error {
+	segment := gpxSegment{Points: make([]gpxPoint, len(track.Points))}
+	for i, p := range track.Points {
+		segment.Points[i] = gpxPoint{Lat: p.Latitude, Lon: p.Longitude, Time: p.Time.UTC()}
+	}
+
+	doc := gpxDocument{
+		Version:  "1.1",
+		Creator:  "locastream",
+		XMLNS:    "http://www.topografix.com/GPX/1/1",
+		Metadata: &gpxMetadata{Name: track.DeviceID},
+		Tracks:   []gpxTrack{{Name: track.DeviceID, Segments: []gpxSegment{segment}}},
+	}
+
+	if start := track.Start(); !start.IsZero() {
+		start = start.UTC()
+		doc.Metadata.Time = &start
+	}
+
+	return writeXML(w, doc)
+}
+
+// writeXML writes an indented XML document with its declaration
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
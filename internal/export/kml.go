@@ -0,0 +1,75 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KML 2.2 document with a placemark for the track
+type kmlDocument struct {
+	XMLName  xml.Name    `xml:"kml"`
+	XMLNS    string      `xml:"xmlns,attr"`
+	Document kmlContents `xml:"Document"`
+}
+
+type kmlContents struct {
+	Name      string       `xml:"name"`
+	Placemark kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name       string         `xml:"name"`
+	TimeSpan   *kmlTimeSpan   `xml:"TimeSpan,omitempty"`
+	LineString *kmlLineString `xml:"LineString,omitempty"`
+	Point      *kmlPoint      `xml:"Point,omitempty"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// WriteKML encodes the track as a KML document with a single placemark, a
+// LineString spanning the time range of the track
+func WriteKML(w io.Writer, track Track) error {
+	placemark := kmlPlacemark{Name: track.DeviceID}
+
+	coords := make([]string, len(track.Points))
+	for i, p := range track.Points {
+		coords[i] = strconv.FormatFloat(p.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(p.Latitude, 'f', -1, 64)
+	}
+
+	switch len(coords) {
+	case 0:
+	case 1:
+		placemark.Point = &kmlPoint{Coordinates: coords[0]}
+	default:
+		placemark.LineString = &kmlLineString{Tessellate: 1, Coordinates: strings.Join(coords, " ")}
+	}
+
+	if len(track.Points) > 0 {
+		placemark.TimeSpan = &kmlTimeSpan{
+			Begin: track.Start().UTC().Format(time.RFC3339),
+			End:   track.End().UTC().Format(time.RFC3339),
+		}
+	}
+
+	doc := kmlDocument{
+		XMLNS:    "http://www.opengis.net/kml/2.2",
+		Document: kmlContents{Name: track.DeviceID, Placemark: placemark},
+	}
+
+	return writeXML(w, doc)
+}
@@ -0,0 +1,66 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BBox is a bounding box in degrees. A box whose MinLon is greater than its
+// MaxLon crosses the antimeridian
+type BBox struct {
+	MinLon float64 `json:"minLon"`
+	MinLat float64 `json:"minLat"`
+	MaxLon float64 `json:"maxLon"`
+	MaxLat float64 `json:"maxLat"`
+}
+
+// ParseBBox parses a bounding box written as minLon,minLat,maxLon,maxLat
+func ParseBBox(value string) (BBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return BBox{}, fmt.Errorf("bbox value %q is not a number", part)
+		}
+		v[i] = f
+	}
+
+	box := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+
+	return box, box.Validate()
+}
+
+// Validate reports an error if the box is outside the valid coordinate ranges
+// or its latitudes are inverted
+func (b BBox) Validate() error {
+	if b.MinLat < -90 || b.MaxLat > 90 || b.MinLat > b.MaxLat {
+		return fmt.Errorf("bbox latitudes must satisfy -90 <= minLat <= maxLat <= 90")
+	}
+
+	if b.MinLon < -180 || b.MinLon > 180 || b.MaxLon < -180 || b.MaxLon > 180 {
+		return fmt.Errorf("bbox longitudes must be between -180 and 180")
+	}
+
+	return nil
+}
+
+// Contains reports whether the point lies inside the box, edges included
+func (b BBox) Contains(lat, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+
+	// The box crosses the antimeridian
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
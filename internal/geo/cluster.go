@@ -0,0 +1,83 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// ClusterCellsPerTile is how many cluster cells span a map tile, so clusters
+// are about a quarter of a tile apart
+const ClusterCellsPerTile = 4
+
+// Cluster is a group of points in one cell, located at their centroid. ID is
+// set when the cluster holds a single point
+type Cluster struct {
+	Lat   float64
+	Lon   float64
+	Count int
+	ID    string
+}
+
+// ClusterCellSize returns the size in degrees of the cells points are grouped
+// in at a web map zoom level
+func ClusterCellSize(zoom int) float64 {
+	return 360 / math.Exp2(float64(zoom)) / ClusterCellsPerTile
+}
+
+// Clusterer groups points by the cells of a grid
+type Clusterer struct {
+	grid  Grid
+	cells map[cell]*Cluster
+}
+
+// NewClusterer returns a clusterer whose cells are size degrees wide and high
+func NewClusterer(size float64) *Clusterer {
+	return &Clusterer{
+		grid:  Grid{size: size},
+		cells: make(map[cell]*Cluster),
+	}
+}
+
+// Add puts a point into the cluster of its cell
+func (c *Clusterer) Add(id string, lat, lon float64) {
+	key := c.grid.cellOf(lat, lon)
+
+	cluster, ok := c.cells[key]
+	if !ok {
+		cluster = &Cluster{}
+		c.cells[key] = cluster
+	}
+
+	// The running mean keeps the centroid without summing large values
+	cluster.Count++
+	cluster.Lat += (lat - cluster.Lat) / float64(cluster.Count)
+	cluster.Lon += (lon - cluster.Lon) / float64(cluster.Count)
+
+	if cluster.Count == 1 {
+		cluster.ID = id
+	} else {
+		cluster.ID = ""
+	}
+}
+
+// Clusters returns the clusters ordered from south-west to north-east
+func (c *Clusterer) Clusters() []Cluster {
+	keys := make([]cell, 0, len(c.cells))
+	for key := range c.cells {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].y != keys[j].y {
+			return keys[i].y < keys[j].y
+		}
+		return keys[i].x < keys[j].x
+	})
+
+	clusters := make([]Cluster, len(keys))
+	for i, key := range keys {
+		clusters[i] = *c.cells[key]
+	}
+
+	return clusters
+}
@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"math"
+	"time"
+)
+
+// EarthRadius is the mean radius of the Earth in meters
+const EarthRadius = 6371008.8
+
+// Point is a position on a track
+type Point struct {
+	Lat  float64
+	Lon  float64
+	Time time.Time
+}
+
+// Distance returns the great-circle distance between two points in meters,
+// using the haversine formula
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := radians(lat1)
+	phi2 := radians(lat2)
+	dPhi := radians(lat2 - lat1)
+	dLambda := radians(lon2 - lon1)
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+
+	return 2 * EarthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Bearing returns the initial bearing from the first point to the second in
+// degrees clockwise from north, in [0, 360)
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := radians(lat1)
+	phi2 := radians(lat2)
+	dLambda := radians(lon2 - lon1)
+
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// project maps a point to meters on a plane tangent at the reference latitude.
+// It is accurate enough for the short distances between neighbouring fixes
+func project(lat, lon, refLat float64) (x, y float64) {
+	return radians(lon) * math.Cos(radians(refLat)) * EarthRadius, radians(lat) * EarthRadius
+}
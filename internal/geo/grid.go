@@ -0,0 +1,134 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// Grid is a spatial index of named points on a grid of square cells. It is not
+// safe for concurrent use
+type Grid struct {
+	size   float64
+	cells  map[cell]map[string]struct{}
+	points map[string]gridPoint
+}
+
+// cell is the column and row of a grid cell
+type cell struct {
+	x, y int
+}
+
+// gridPoint is an indexed point and the cell it falls in
+type gridPoint struct {
+	lat, lon float64
+	cell     cell
+}
+
+// NewGrid returns an empty grid whose cells are size degrees wide and high
+func NewGrid(size float64) *Grid {
+	return &Grid{
+		size:   size,
+		cells:  make(map[cell]map[string]struct{}),
+		points: make(map[string]gridPoint),
+	}
+}
+
+// Set indexes the point under the ID, moving it if the ID is already indexed
+func (g *Grid) Set(id string, lat, lon float64) {
+	c := g.cellOf(lat, lon)
+
+	if old, ok := g.points[id]; ok && old.cell != c {
+		g.removeFromCell(id, old.cell)
+	}
+
+	members, ok := g.cells[c]
+	if !ok {
+		members = make(map[string]struct{})
+		g.cells[c] = members
+	}
+
+	members[id] = struct{}{}
+	g.points[id] = gridPoint{lat: lat, lon: lon, cell: c}
+}
+
+// Remove drops the point indexed under the ID
+func (g *Grid) Remove(id string) {
+	old, ok := g.points[id]
+	if !ok {
+		return
+	}
+
+	g.removeFromCell(id, old.cell)
+	delete(g.points, id)
+}
+
+// Within returns the IDs of the points inside the box, sorted
+func (g *Grid) Within(box BBox) []string {
+	var ids []string
+
+	// A box crossing the antimeridian is searched as its two halves
+	if box.MinLon > box.MaxLon {
+		ids = g.within(BBox{MinLon: box.MinLon, MinLat: box.MinLat, MaxLon: 180, MaxLat: box.MaxLat}, ids)
+		ids = g.within(BBox{MinLon: -180, MinLat: box.MinLat, MaxLon: box.MaxLon, MaxLat: box.MaxLat}, ids)
+	} else {
+		ids = g.within(box, ids)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// within appends the IDs of the points inside a box that doesn't cross the antimeridian
+func (g *Grid) within(box BBox, ids []string) []string {
+	lo := g.cellOf(box.MinLat, box.MinLon)
+	hi := g.cellOf(box.MaxLat, box.MaxLon)
+
+	inRange := func(c cell) bool {
+		return c.x >= lo.x && c.x <= hi.x && c.y >= lo.y && c.y <= hi.y
+	}
+
+	collect := func(members map[string]struct{}) {
+		for id := range members {
+			p := g.points[id]
+			if box.Contains(p.lat, p.lon) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	// Large boxes have fewer occupied cells than cells in range, scan those instead
+	if span := float64(hi.x-lo.x+1) * float64(hi.y-lo.y+1); span > float64(len(g.cells)) {
+		for c, members := range g.cells {
+			if inRange(c) {
+				collect(members)
+			}
+		}
+		return ids
+	}
+
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			collect(g.cells[cell{x, y}])
+		}
+	}
+
+	return ids
+}
+
+// cellOf returns the cell the coordinates fall in
+func (g *Grid) cellOf(lat, lon float64) cell {
+	return cell{
+		x: int(math.Floor(lon / g.size)),
+		y: int(math.Floor(lat / g.size)),
+	}
+}
+
+func (g *Grid) removeFromCell(id string, c cell) {
+	members := g.cells[c]
+	delete(members, id)
+
+	if len(members) == 0 {
+		delete(g.cells, c)
+	}
+}
@@ -0,0 +1,94 @@
+package geo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGridWithin(t *testing.T) {
+	g := NewGrid(0.25)
+
+	points := map[string][2]float64{
+		"origin":     {0, 0},
+		"edge":       {0.25, 0.25},
+		"south-west": {-0.1, -0.1},
+		"east":       {0, 10},
+		"far-east":   {0, 179.9},
+		"far-west":   {0, -179.9},
+		"dateline":   {0, 180},
+		"north":      {80, 0},
+	}
+	for id, p := range points {
+		g.Set(id, p[0], p[1])
+	}
+
+	// Moved and removed points are only found where they are now
+	g.Set("moved", 0, 100)
+	g.Set("moved", 0, 0.1)
+	g.Set("removed", 0, 0.1)
+	g.Remove("removed")
+
+	tests := []struct {
+		name string
+		box  BBox
+		want []string
+	}{
+		{
+			name: "around the origin",
+			box:  BBox{MinLon: -0.2, MinLat: -0.2, MaxLon: 0.2, MaxLat: 0.2},
+			want: []string{"moved", "origin", "south-west"},
+		},
+		{
+			name: "edges included",
+			box:  BBox{MinLon: 0, MinLat: 0, MaxLon: 0.25, MaxLat: 0.25},
+			want: []string{"edge", "moved", "origin"},
+		},
+		{
+			name: "panned east",
+			box:  BBox{MinLon: 0.2, MinLat: -0.2, MaxLon: 10.2, MaxLat: 0.3},
+			want: []string{"east", "edge"},
+		},
+		{
+			name: "moved away",
+			box:  BBox{MinLon: 99, MinLat: -1, MaxLon: 101, MaxLat: 1},
+		},
+		{
+			name: "across the antimeridian",
+			box:  BBox{MinLon: 179, MinLat: -1, MaxLon: -179, MaxLat: 1},
+			want: []string{"dateline", "far-east", "far-west"},
+		},
+		{
+			name: "west half of the antimeridian",
+			box:  BBox{MinLon: 179.95, MinLat: -1, MaxLon: -179, MaxLat: 1},
+			want: []string{"dateline", "far-west"},
+		},
+		{
+			name: "east of the antimeridian only",
+			box:  BBox{MinLon: 179, MinLat: -1, MaxLon: 179.95, MaxLat: 1},
+			want: []string{"far-east"},
+		},
+		{
+			name: "whole world",
+			box:  BBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90},
+			want: []string{"dateline", "east", "edge", "far-east", "far-west", "moved", "north", "origin", "south-west"},
+		},
+		{
+			name: "whole world across the antimeridian",
+			box:  BBox{MinLon: 0.1, MinLat: -90, MaxLon: 0, MaxLat: 90},
+			want: []string{"dateline", "east", "edge", "far-east", "far-west", "moved", "north", "origin", "south-west"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.Within(tt.box)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Within(%+v) = %v, want %v", tt.box, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package geo
+
+import "math"
+
+// Line is a polyline with the distance along it to each of its vertices
+type Line struct {
+	points []Point
+
+	// Distance in meters from the first vertex to each vertex
+	along []float64
+}
+
+// SnapWindow is how many segments on either side of a hint SnapNear searches
+const SnapWindow = 64
+
+// Snap is the point of a line closest to a position
+type Snap struct {
+	Lat float64
+	Lon float64
+
+	// Along is the distance in meters from the start of the line to the point
+	Along float64
+
+	// Distance is the cross-track distance in meters from the position to the point
+	Distance float64
+
+	// Segment is the index of the segment the point lies on
+	Segment int
+}
+
+// NewLine returns a line through the points, which must hold at least two
+func NewLine(points []Point) *Line {
+	l := &Line{points: points, along: make([]float64, len(points))}
+
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		l.along[i] = l.along[i-1] + Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+
+	return l
+}
+
+// Length returns the length of the line in meters
+func (l *Line) Length() float64 {
+	if len(l.along) == 0 {
+		return 0
+	}
+
+	return l.along[len(l.along)-1]
+}
+
+// Snap returns the point of the line closest to the position
+func (l *Line) Snap(lat, lon float64) Snap {
+	return l.snapBetween(Point{Lat: lat, Lon: lon}, 0, len(l.points)-2)
+}
+
+// SnapNear returns the point closest to the position among the segments
+// around the segment hint, typically where a device following the line was
+// last snapped. If none of them is within maxDistance meters of the position
+// it searches the whole line like Snap
+func (l *Line) SnapNear(lat, lon float64, hint int, maxDistance float64) Snap {
+	p := Point{Lat: lat, Lon: lon}
+
+	first := max(hint-SnapWindow, 0)
+	last := min(hint+SnapWindow, len(l.points)-2)
+
+	if first <= last {
+		if best := l.snapBetween(p, first, last); best.Distance <= maxDistance {
+			return best
+		}
+	}
+
+	return l.snapBetween(p, 0, len(l.points)-2)
+}
+
+// snapBetween returns the point closest to p on the segments first to last
+func (l *Line) snapBetween(p Point, first, last int) Snap {
+	best := Snap{Distance: math.Inf(1)}
+
+	for i := first + 1; i <= last+1; i++ {
+		a, b := l.points[i-1], l.points[i]
+
+		t, d := closestOnSegment(p, a, b)
+		if d >= best.Distance {
+			continue
+		}
+
+		best = Snap{
+			Lat:      a.Lat + t*(b.Lat-a.Lat),
+			Lon:      a.Lon + t*(b.Lon-a.Lon),
+			Along:    l.along[i-1] + t*(l.along[i]-l.along[i-1]),
+			Distance: d,
+			Segment:  i - 1,
+		}
+	}
+
+	return best
+}
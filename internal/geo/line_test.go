@@ -0,0 +1,64 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// eastward returns a line of n vertices along the equator, 0.001 degrees apart
+func eastward(n int) *Line {
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = Point{Lat: 0, Lon: float64(i) * 0.001}
+	}
+
+	return NewLine(points)
+}
+
+func TestLineSnap(t *testing.T) {
+	l := eastward(1000)
+
+	snap := l.Snap(0.0001, 0.5005)
+	if snap.Segment != 500 || snap.Lat != 0 || math.Abs(snap.Lon-0.5005) > 1e-9 {
+		t.Errorf("got %+v, want segment 500 at 0,0.5005", snap)
+	}
+
+	if math.Abs(snap.Distance-Distance(0.0001, 0.5005, 0, 0.5005)) > 0.01 {
+		t.Errorf("cross-track distance %v", snap.Distance)
+	}
+
+	if math.Abs(snap.Along-Distance(0, 0, 0, 0.5005)) > 0.01 {
+		t.Errorf("along %v", snap.Along)
+	}
+}
+
+func TestLineSnapNear(t *testing.T) {
+	l := eastward(1000)
+
+	tests := []struct {
+		name        string
+		lat, lon    float64
+		hint        int
+		maxDistance float64
+		segment     int
+	}{
+		{name: "near the hint", lat: 0.0001, lon: 0.5105, hint: 500, maxDistance: 100, segment: 510},
+		{name: "hint at the start", lat: 0.0001, lon: 0.0005, hint: 0, maxDistance: 100, segment: 0},
+		{name: "hint at the end", lat: 0.0001, lon: 0.9985, hint: 998, maxDistance: 100, segment: 998},
+		{name: "jumped past the window", lat: 0.0001, lon: 0.8005, hint: 100, maxDistance: 100, segment: 800},
+		{name: "off the line", lat: 0.01, lon: 0.3005, hint: 700, maxDistance: 100, segment: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := l.SnapNear(tt.lat, tt.lon, tt.hint, tt.maxDistance)
+			if got.Segment != tt.segment {
+				t.Errorf("snapped to segment %d, want %d", got.Segment, tt.segment)
+			}
+
+			if want := l.Snap(tt.lat, tt.lon); got != want {
+				t.Errorf("SnapNear = %+v, Snap = %+v", got, want)
+			}
+		})
+	}
+}
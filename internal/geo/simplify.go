@@ -0,0 +1,150 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// SimplifyDP reduces a track to at most maxPoints points with the
+// Douglas-Peucker algorithm. Instead of a distance tolerance it ranks every
+// point by the deviation it had when Douglas-Peucker split on it, and keeps
+// the most significant ones. It returns the indices of the kept points in order
+func SimplifyDP(points []Point, maxPoints int) []int {
+	n := len(points)
+	if maxPoints <= 0 || n <= maxPoints {
+		return allIndices(n)
+	}
+
+	if maxPoints < 2 {
+		maxPoints = 2
+	}
+
+	significance := make([]float64, n)
+	significance[0] = math.Inf(1)
+	significance[n-1] = math.Inf(1)
+
+	// Iterative to keep deep recursion off the stack on long tracks
+	type span struct{ first, last int }
+	stack := []span{{0, n - 1}}
+
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if s.last-s.first < 2 {
+			continue
+		}
+
+		// Ties go to the point nearest the middle so runs of colinear
+		// points are halved instead of peeled off one at a time
+		mid := (s.first + s.last) / 2
+		split, maxDist := -1, -1.0
+		for i := s.first + 1; i < s.last; i++ {
+			d := segmentDistance(points[i], points[s.first], points[s.last])
+			if d > maxDist || d == maxDist && abs(i-mid) < abs(split-mid) {
+				split, maxDist = i, d
+			}
+		}
+
+		// Nothing in a flat span is more significant than anything else in it
+		if maxDist <= 0 {
+			continue
+		}
+
+		significance[split] = maxDist
+		stack = append(stack, span{s.first, split}, span{split, s.last})
+	}
+
+	ranked := allIndices(n)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return significance[ranked[i]] > significance[ranked[j]]
+	})
+
+	kept := ranked[:maxPoints]
+	sort.Ints(kept)
+
+	return kept
+}
+
+// BucketByTime reduces a track to at most maxPoints points by splitting its
+// time span into equal buckets and keeping the last point of each. The first
+// point is always kept. It returns the indices of the kept points in order
+func BucketByTime(points []Point, maxPoints int) []int {
+	n := len(points)
+	if maxPoints <= 0 || n <= maxPoints {
+		return allIndices(n)
+	}
+
+	if maxPoints < 2 {
+		maxPoints = 2
+	}
+
+	start := points[0].Time
+	span := points[n-1].Time.Sub(start)
+	buckets := maxPoints - 1
+
+	kept := []int{0}
+	lastBucket := -1
+
+	for i := 1; i < n; i++ {
+		bucket := buckets - 1
+		if span > 0 {
+			bucket = int(float64(points[i].Time.Sub(start)) / float64(span) * float64(buckets))
+			if bucket >= buckets {
+				bucket = buckets - 1
+			}
+		}
+
+		if bucket == lastBucket {
+			kept[len(kept)-1] = i
+			continue
+		}
+
+		kept = append(kept, i)
+		lastBucket = bucket
+	}
+
+	return kept
+}
+
+// segmentDistance returns the distance in meters from p to the segment a-b
+func segmentDistance(p, a, b Point) float64 {
+	_, d := closestOnSegment(p, a, b)
+
+	return d
+}
+
+// closestOnSegment returns how far along the segment a-b the point closest to
+// p lies, from 0 at a to 1 at b, and its distance from p in meters
+func closestOnSegment(p, a, b Point) (t, distance float64) {
+	px, py := project(p.Lat, p.Lon, a.Lat)
+	ax, ay := project(a.Lat, a.Lon, a.Lat)
+	bx, by := project(b.Lat, b.Lon, a.Lat)
+
+	dx, dy := bx-ax, by-ay
+	if dx == 0 && dy == 0 {
+		return 0, math.Hypot(px-ax, py-ay)
+	}
+
+	t = ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+
+	return t, math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func allIndices(n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+
+	return indices
+}
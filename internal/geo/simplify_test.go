@@ -0,0 +1,83 @@
+package geo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimplifyDP(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	track := func(coords ...[2]float64) []Point {
+		points := make([]Point, len(coords))
+		for i, c := range coords {
+			points[i] = Point{Lat: c[0], Lon: c[1], Time: start.Add(time.Duration(i) * time.Second)}
+		}
+		return points
+	}
+
+	tests := []struct {
+		name      string
+		points    []Point
+		maxPoints int
+		want      []int
+	}{
+		{
+			name:      "under the limit",
+			points:    track([2]float64{0, 0}, [2]float64{0, 1}, [2]float64{1, 1}),
+			maxPoints: 5,
+			want:      []int{0, 1, 2},
+		},
+		{
+			name:      "keeps the corner",
+			points:    track([2]float64{0, 0}, [2]float64{0, 0.5}, [2]float64{0, 1}, [2]float64{0.5, 1}, [2]float64{1, 1}),
+			maxPoints: 3,
+			want:      []int{0, 2, 4},
+		},
+		{
+			name:      "keeps the largest detour",
+			points:    track([2]float64{0, 0}, [2]float64{0.001, 0.25}, [2]float64{0, 0.5}, [2]float64{0.01, 0.75}, [2]float64{0, 1}),
+			maxPoints: 3,
+			want:      []int{0, 3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SimplifyDP(tt.points, tt.maxPoints); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SimplifyDP = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplifyDPFlatTracks(t *testing.T) {
+	const n = 43200
+
+	tests := []struct {
+		name  string
+		point func(i int) Point
+	}{
+		{name: "stationary", point: func(i int) Point { return Point{Lat: 23.81, Lon: 90.41} }},
+		{name: "colinear", point: func(i int) Point { return Point{Lat: 0, Lon: float64(i) * 1e-5} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make([]Point, n)
+			for i := range points {
+				points[i] = tt.point(i)
+			}
+
+			began := time.Now()
+			kept := SimplifyDP(points, 1000)
+			if elapsed := time.Since(began); elapsed > 5*time.Second {
+				t.Errorf("SimplifyDP took %v", elapsed)
+			}
+
+			if len(kept) != 1000 || kept[0] != 0 || kept[len(kept)-1] != n-1 {
+				t.Errorf("kept %d points from %d to %d", len(kept), kept[0], kept[len(kept)-1])
+			}
+		})
+	}
+}
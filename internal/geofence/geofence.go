@@ -0,0 +1,140 @@
+package geofence
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// Fence shapes
+const (
+	ShapeCircle  = "circle"
+	ShapePolygon = "polygon"
+)
+
+// MaxVertices is the largest number of vertices a polygon fence may have
+const MaxVertices = 1000
+
+// Coordinate is a position in degrees
+type Coordinate struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Fence is an area devices on a channel are tracked entering and leaving
+type Fence struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Channel string `json:"channel"`
+	Shape   string `json:"shape"`
+
+	// Center and Radius in meters describe circles
+	Center *Coordinate `json:"center,omitempty"`
+	Radius float64     `json:"radius,omitempty"`
+
+	// Polygon is the ring of a polygon as [longitude, latitude] pairs, like
+	// GeoJSON. It is closed implicitly
+	Polygon [][2]float64 `json:"polygon,omitempty"`
+
+	// DwellSeconds is how long a device must stay inside before a dwell event
+	// is raised, 0 for no dwell events
+	DwellSeconds float64 `json:"dwellSeconds,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks that the fence describes a usable area
+func (f *Fence) Validate() error {
+	switch f.Shape {
+	case ShapeCircle:
+		if f.Center == nil {
+			return fmt.Errorf("circle fences need a center")
+		}
+
+		if err := checkCoordinate(f.Center.Longitude, f.Center.Latitude); err != nil {
+			return fmt.Errorf("center: %v", err)
+		}
+
+		if !(f.Radius > 0) || math.IsInf(f.Radius, 0) {
+			return fmt.Errorf("radius must be a positive number of meters")
+		}
+
+		if len(f.Polygon) > 0 {
+			return fmt.Errorf("circle fences have no polygon")
+		}
+	case ShapePolygon:
+		if len(f.Polygon) < 3 {
+			return fmt.Errorf("polygon fences need at least 3 vertices")
+		}
+
+		if len(f.Polygon) > MaxVertices {
+			return fmt.Errorf("polygon fences have at most %d vertices", MaxVertices)
+		}
+
+		for i, v := range f.Polygon {
+			if err := checkCoordinate(v[0], v[1]); err != nil {
+				return fmt.Errorf("polygon vertex %d: %v", i, err)
+			}
+		}
+
+		if f.Center != nil || f.Radius != 0 {
+			return fmt.Errorf("polygon fences have no center or radius")
+		}
+	default:
+		return fmt.Errorf("shape must be %q or %q", ShapeCircle, ShapePolygon)
+	}
+
+	if f.DwellSeconds < 0 || math.IsNaN(f.DwellSeconds) || math.IsInf(f.DwellSeconds, 0) {
+		return fmt.Errorf("dwellSeconds must not be negative")
+	}
+
+	return nil
+}
+
+// Contains reports whether the position is inside the fence
+func (f *Fence) Contains(lat, lon float64) bool {
+	switch f.Shape {
+	case ShapeCircle:
+		return geo.Distance(lat, lon, f.Center.Latitude, f.Center.Longitude) <= f.Radius
+	case ShapePolygon:
+		return inPolygon(f.Polygon, lat, lon)
+	default:
+		return false
+	}
+}
+
+// dwell returns the dwell time of the fence
+func (f *Fence) dwell() time.Duration {
+	return time.Duration(f.DwellSeconds * float64(time.Second))
+}
+
+// inPolygon casts a ray along the latitude and counts the edges it crosses
+func inPolygon(ring [][2]float64, lat, lon float64) bool {
+	inside := false
+
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// checkCoordinate checks that a longitude and latitude are in range
+func checkCoordinate(lon, lat float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+
+	if math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package geofence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInPolygon(t *testing.T) {
+	square := [][2]float64{{90.40, 23.80}, {90.42, 23.80}, {90.42, 23.82}, {90.40, 23.82}}
+
+	// An L shape with its notch in the north-east quarter
+	concave := [][2]float64{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+
+	tests := []struct {
+		name     string
+		ring     [][2]float64
+		lat, lon float64
+		want     bool
+	}{
+		{name: "square center", ring: square, lat: 23.81, lon: 90.41, want: true},
+		{name: "square north", ring: square, lat: 23.83, lon: 90.41},
+		{name: "square east", ring: square, lat: 23.81, lon: 90.43},
+		{name: "square west", ring: square, lat: 23.81, lon: 90.39},
+		{name: "concave arm", ring: concave, lat: 1.5, lon: 0.5, want: true},
+		{name: "concave foot", ring: concave, lat: 0.5, lon: 1.5, want: true},
+		{name: "concave notch", ring: concave, lat: 1.5, lon: 1.5},
+		{name: "level with a vertex", ring: concave, lat: 1, lon: 0.5, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inPolygon(tt.ring, tt.lat, tt.lon); got != tt.want {
+				t.Errorf("inPolygon(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateDwell(t *testing.T) {
+	r, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Add(Fence{
+		ID:           "depot",
+		Channel:      "fleet-a",
+		Shape:        ShapeCircle,
+		Center:       &Coordinate{Latitude: 23.81, Longitude: 90.41},
+		Radius:       250,
+		DwellSeconds: 600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	inside := [2]float64{23.81, 90.41}
+	outside := [2]float64{23.83, 90.41}
+
+	steps := []struct {
+		at      time.Duration
+		channel string
+		pos     [2]float64
+		want    []string
+	}{
+		{at: 0, pos: outside},
+		{at: time.Minute, pos: inside, want: []string{EventEnter}},
+		{at: 5 * time.Minute, pos: inside},
+		{at: 11 * time.Minute, pos: inside, want: []string{EventDwell}},
+		{at: 15 * time.Minute, pos: inside},
+		{at: 15 * time.Minute, channel: "fleet-b", pos: outside},
+		{at: 20 * time.Minute, pos: outside, want: []string{EventExit}},
+		{at: 21 * time.Minute, pos: inside, want: []string{EventEnter}},
+		{at: 25 * time.Minute, pos: outside, want: []string{EventExit}},
+	}
+
+	for _, step := range steps {
+		channel := step.channel
+		if channel == "" {
+			channel = "fleet-a"
+		}
+
+		events := r.Evaluate("truck-42", channel, step.pos[0], step.pos[1], start.Add(step.at))
+
+		var got []string
+		for _, e := range events {
+			got = append(got, e.Type)
+		}
+
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("at %v on %s got %v, want %v", step.at, channel, got, step.want)
+		}
+
+		for _, e := range events {
+			if e.Type == EventDwell && e.InsideSeconds != 600 {
+				t.Errorf("dwell after %v seconds inside, want 600", e.InsideSeconds)
+			}
+			if e.Type == EventExit && step.at == 20*time.Minute && e.InsideSeconds != 1140 {
+				t.Errorf("exit after %v seconds inside, want 1140", e.InsideSeconds)
+			}
+		}
+	}
+}
+
+func TestEvaluateOutOfOrder(t *testing.T) {
+	r, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Add(Fence{
+		ID:           "depot",
+		Channel:      "fleet-a",
+		Shape:        ShapeCircle,
+		Center:       &Coordinate{Latitude: 23.81, Longitude: 90.41},
+		Radius:       250,
+		DwellSeconds: 600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	inside := [2]float64{23.81, 90.41}
+	outside := [2]float64{23.83, 90.41}
+
+	steps := []struct {
+		device string
+		at     time.Duration
+		pos    [2]float64
+		want   []string
+	}{
+		{device: "truck-42", at: time.Minute, pos: inside, want: []string{EventEnter}},
+		// Published before the enter but evaluated after it
+		{device: "truck-42", at: 0, pos: outside},
+		{device: "truck-42", at: 30 * time.Second, pos: outside},
+		// Other devices keep their own order
+		{device: "truck-7", at: 0, pos: inside, want: []string{EventEnter}},
+		{device: "truck-42", at: time.Minute, pos: inside},
+		{device: "truck-42", at: 12 * time.Minute, pos: inside, want: []string{EventDwell}},
+		{device: "truck-42", at: 11 * time.Minute, pos: outside},
+		{device: "truck-42", at: 13 * time.Minute, pos: outside, want: []string{EventExit}},
+	}
+
+	for _, step := range steps {
+		events := r.Evaluate(step.device, "fleet-a", step.pos[0], step.pos[1], start.Add(step.at))
+
+		var got []string
+		for _, e := range events {
+			got = append(got, e.Type)
+
+			if e.InsideSeconds < 0 {
+				t.Errorf("%s %s after %v seconds inside", step.device, e.Type, e.InsideSeconds)
+			}
+		}
+
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s at %v got %v, want %v", step.device, step.at, got, step.want)
+		}
+
+		for _, e := range events {
+			if e.Type == EventExit && e.InsideSeconds != 720 {
+				t.Errorf("exit after %v seconds inside, want 720", e.InsideSeconds)
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package geofence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+	EventDwell = "dwell"
+)
+
+// ErrNotFound is returned for fences that don't exist
+var ErrNotFound = errors.New("geofence not found")
+
+// Event is raised when a device crosses or lingers in a fence
+type Event struct {
+	Type      string    `json:"type"`
+	FenceID   string    `json:"fenceId"`
+	FenceName string    `json:"fenceName,omitempty"`
+	DeviceID  string    `json:"deviceId"`
+	Channel   string    `json:"channel"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Time      time.Time `json:"time"`
+
+	// Seconds the device had been inside the fence, for exit and dwell events
+	InsideSeconds float64 `json:"insideSeconds,omitempty"`
+}
+
+// presence is where a device stands with respect to one fence
+type presence struct {
+	since   time.Time
+	dwelled bool
+}
+
+// presenceKey identifies the presence of a device in a fence
+type presenceKey struct {
+	fenceID  string
+	deviceID string
+}
+
+// Registry holds the fences, saves them to a file and tracks which devices are
+// inside them
+type Registry struct {
+	mutex  sync.Mutex
+	path   string
+	fences map[string]*Fence
+
+	// Devices currently inside a fence
+	inside map[presenceKey]*presence
+
+	// Time of the last fix evaluated for each device
+	evaluated map[string]time.Time
+}
+
+// Open loads the fences saved in the file, which need not exist yet. An empty
+// path keeps fences in memory only
+func Open(path string) (*Registry, error) {
+	r := &Registry{
+		path:      path,
+		fences:    make(map[string]*Fence),
+		inside:    make(map[presenceKey]*presence),
+		evaluated: make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fences []*Fence
+	if err := json.Unmarshal(data, &fences); err != nil {
+		return nil, fmt.Errorf("error parsing geofences file %s: %v", path, err)
+	}
+
+	for _, f := range fences {
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("geofence %s in %s: %v", f.ID, path, err)
+		}
+		r.fences[f.ID] = f
+	}
+
+	return r, nil
+}
+
+// Add validates and saves a new fence, assigning it an ID if it has none
+func (r *Registry) Add(f Fence) (Fence, error) {
+	if err := f.Validate(); err != nil {
+		return Fence{}, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if f.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return Fence{}, err
+		}
+		f.ID = id
+	}
+
+	if _, ok := r.fences[f.ID]; ok {
+		return Fence{}, fmt.Errorf("geofence %s already exists", f.ID)
+	}
+
+	f.CreatedAt = time.Now().UTC()
+	r.fences[f.ID] = &f
+
+	if err := r.save(); err != nil {
+		delete(r.fences, f.ID)
+		return Fence{}, err
+	}
+
+	return f, nil
+}
+
+// Remove deletes a fence. Devices inside it get no exit event
+func (r *Registry) Remove(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.fences[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(r.fences, id)
+
+	if err := r.save(); err != nil {
+		r.fences[id] = f
+		return err
+	}
+
+	for key := range r.inside {
+		if key.fenceID == id {
+			delete(r.inside, key)
+		}
+	}
+
+	return nil
+}
+
+// Get returns a fence by ID
+func (r *Registry) Get(id string) (Fence, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.fences[id]
+	if !ok {
+		return Fence{}, false
+	}
+
+	return *f, true
+}
+
+// List returns the fences that pass the filter, ordered by ID
+func (r *Registry) List(filter func(Fence) bool) []Fence {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	list := []Fence{}
+	for _, f := range r.fences {
+		if filter(*f) {
+			list = append(list, *f)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// Evaluate checks a device's position against the fences of its channel and
+// returns the events it raises. Fixes older than the last one evaluated for
+// the device arrived out of order and are ignored
+func (r *Registry) Evaluate(deviceID, channel string, lat, lon float64, at time.Time) []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if last, ok := r.evaluated[deviceID]; ok && at.Before(last) {
+		return nil
+	}
+	r.evaluated[deviceID] = at
+
+	var events []Event
+
+	for _, f := range r.fences {
+		if f.Channel != channel {
+			continue
+		}
+
+		key := presenceKey{fenceID: f.ID, deviceID: deviceID}
+		p, wasInside := r.inside[key]
+		isInside := f.Contains(lat, lon)
+
+		event := Event{
+			FenceID:   f.ID,
+			FenceName: f.Name,
+			DeviceID:  deviceID,
+			Channel:   channel,
+			Latitude:  lat,
+			Longitude: lon,
+			Time:      at,
+		}
+
+		switch {
+		case isInside && !wasInside:
+			r.inside[key] = &presence{since: at}
+
+			event.Type = EventEnter
+			events = append(events, event)
+		case !isInside && wasInside:
+			delete(r.inside, key)
+
+			event.Type = EventExit
+			event.InsideSeconds = at.Sub(p.since).Seconds()
+			events = append(events, event)
+		case isInside && !p.dwelled && f.DwellSeconds > 0 && at.Sub(p.since) >= f.dwell():
+			p.dwelled = true
+
+			event.Type = EventDwell
+			event.InsideSeconds = at.Sub(p.since).Seconds()
+			events = append(events, event)
+		}
+	}
+
+	// Map order is random, keep events deterministic
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].FenceID < events[j].FenceID
+	})
+
+	return events
+}
+
+// save writes the fences to the registry's file, replacing it atomically.
+// The mutex must be held
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	fences := make([]*Fence, 0, len(r.fences))
+	for _, f := range r.fences {
+		fences = append(fences, f)
+	}
+
+	sort.Slice(fences, func(i, j int) bool {
+		return fences[i].ID < fences[j].ID
+	})
+
+	data, err := json.MarshalIndent(fences, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
+
+// newID generates a random fence ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
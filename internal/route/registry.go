@@ -0,0 +1,147 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for devices without a route
+var ErrNotFound = errors.New("route not found")
+
+// Registry holds the planned route of each device and saves them to a file
+type Registry struct {
+	mutex  sync.Mutex
+	path   string
+	routes map[string]*Route
+}
+
+// Open loads the routes saved in the file, which need not exist yet. An empty
+// path keeps routes in memory only
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, routes: make(map[string]*Route)}
+
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []*Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("error parsing routes file %s: %v", path, err)
+	}
+
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			return nil, fmt.Errorf("route of %s in %s: %v", route.DeviceID, path, err)
+		}
+
+		route.build()
+		r.routes[route.DeviceID] = route
+	}
+
+	return r, nil
+}
+
+// Set validates and saves the route of its device, replacing any it had
+func (r *Registry) Set(route Route) (*Route, error) {
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+
+	route.CreatedAt = time.Now().UTC()
+	route.build()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous, had := r.routes[route.DeviceID]
+	r.routes[route.DeviceID] = &route
+
+	if err := r.save(); err != nil {
+		if had {
+			r.routes[route.DeviceID] = previous
+		} else {
+			delete(r.routes, route.DeviceID)
+		}
+		return nil, err
+	}
+
+	return &route, nil
+}
+
+// Remove deletes the route of a device
+func (r *Registry) Remove(deviceID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	route, ok := r.routes[deviceID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(r.routes, deviceID)
+
+	if err := r.save(); err != nil {
+		r.routes[deviceID] = route
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the route of a device. Routes are never modified once set, so
+// the returned route can be used without holding any lock
+func (r *Registry) Get(deviceID string) (*Route, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	route, ok := r.routes[deviceID]
+
+	return route, ok
+}
+
+// save writes the routes to the registry's file, replacing it atomically. The
+// mutex must be held
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	routes := make([]*Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].DeviceID < routes[j].DeviceID
+	})
+
+	data, err := json.Marshal(routes)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,85 @@
+package route
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/geo"
+)
+
+// MaxVertices is the largest number of vertices a route may have
+const MaxVertices = 100000
+
+// Route is the planned path of a device's trip
+type Route struct {
+	DeviceID string `json:"deviceId"`
+	Channel  string `json:"channel"`
+	Name     string `json:"name,omitempty"`
+
+	// Coordinates are [longitude, latitude] pairs, like the geometry of an
+	// OSRM route requested with geometries=geojson
+	Coordinates [][2]float64 `json:"coordinates"`
+
+	// Length of the route in meters
+	Length float64 `json:"length"`
+
+	// OffRouteDistance is the cross-track distance in meters beyond which a
+	// fix is off the route, and OffRouteFixes how many fixes in a row it takes
+	// to go off or come back on the route. Zero uses the server defaults
+	OffRouteDistance float64 `json:"offRouteDistance,omitempty"`
+	OffRouteFixes    int     `json:"offRouteFixes,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	line *geo.Line
+}
+
+// Validate checks that the route can be followed
+func (r *Route) Validate() error {
+	if len(r.Coordinates) < 2 {
+		return fmt.Errorf("routes need at least 2 coordinates")
+	}
+
+	if len(r.Coordinates) > MaxVertices {
+		return fmt.Errorf("routes have at most %d coordinates", MaxVertices)
+	}
+
+	if !(r.OffRouteDistance >= 0) || math.IsInf(r.OffRouteDistance, 0) {
+		return fmt.Errorf("offRouteDistance must not be negative")
+	}
+
+	if r.OffRouteFixes < 0 {
+		return fmt.Errorf("offRouteFixes must not be negative")
+	}
+
+	for i, c := range r.Coordinates {
+		if math.IsNaN(c[0]) || c[0] < -180 || c[0] > 180 || math.IsNaN(c[1]) || c[1] < -90 || c[1] > 90 {
+			return fmt.Errorf("coordinate %d is not a valid [longitude, latitude] pair", i)
+		}
+	}
+
+	return nil
+}
+
+// Snap returns the point of the route closest to the position
+func (r *Route) Snap(lat, lon float64) geo.Snap {
+	return r.line.Snap(lat, lon)
+}
+
+// SnapNear returns the point of the route closest to the position, searching
+// around the segment the device was last snapped to first. See geo.Line.SnapNear
+func (r *Route) SnapNear(lat, lon float64, segment int, maxDistance float64) geo.Snap {
+	return r.line.SnapNear(lat, lon, segment, maxDistance)
+}
+
+// build prepares the route for snapping
+func (r *Route) build() {
+	points := make([]geo.Point, len(r.Coordinates))
+	for i, c := range r.Coordinates {
+		points[i] = geo.Point{Lat: c[1], Lon: c[0]}
+	}
+
+	r.line = geo.NewLine(points)
+	r.Length = r.line.Length()
+}
@@ -6,8 +6,26 @@ import (
 )
 
 const (
-	home      = "/home"
-	websocket = "/ws"
+	home        = "/home"
+	websocket   = "/ws"
+	publish     = "/ws/publish"
+	subscribe   = "/ws/subscribe"
+	replay      = "/ws/replay"
+	channels    = "/api/channels"
+	connections = "/api/connections"
+	devices     = "/api/devices"
+	device      = "/api/devices/{id}/position"
+	track       = "/api/devices/{id}/track"
+	export      = "/api/devices/{id}/export"
+	route       = "/api/devices/{id}/route"
+	positions   = "/api/positions"
+	locations   = "/api/locations"
+	stream      = "/api/stream"
+	geofences   = "/api/geofences"
+	geofence    = "/api/geofences/{id}"
+	webhooks    = "/api/webhooks"
+	webhook     = "/api/webhooks/{id}"
+	webhookPing = "/api/webhooks/{id}/ping"
 )
 
 func Routers() *router.Router {
@@ -15,6 +33,30 @@ func Routers() *router.Router {
 
 	r.GET(home, api.Home)
 	r.GET(websocket, api.WebSocket)
+	r.GET(publish, api.PublishWebSocket)
+	r.GET(subscribe, api.SubscribeWebSocket)
+	r.GET(replay, api.ReplayWebSocket)
+	r.GET(channels, api.ListChannels)
+	r.GET(connections, api.ListConnections)
+	r.GET(devices, api.ListDevices)
+	r.GET(device, api.GetDevicePosition)
+	r.GET(track, api.GetTrack)
+	r.GET(export, api.ExportTrack)
+	r.GET(route, api.GetRoute)
+	r.PUT(route, api.SetRoute)
+	r.DELETE(route, api.DeleteRoute)
+	r.GET(positions, api.ListPositions)
+	r.POST(locations, api.IngestLocations)
+	r.GET(stream, api.Stream)
+	r.GET(geofences, api.ListGeofences)
+	r.POST(geofences, api.CreateGeofence)
+	r.GET(geofence, api.GetGeofence)
+	r.DELETE(geofence, api.DeleteGeofence)
+	r.GET(webhooks, api.ListWebhooks)
+	r.POST(webhooks, api.CreateWebhook)
+	r.GET(webhook, api.GetWebhook)
+	r.DELETE(webhook, api.DeleteWebhook)
+	r.POST(webhookPing, api.PingWebhook)
 
 	return r
 }
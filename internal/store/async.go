@@ -0,0 +1,116 @@
+package store
+
+import (
+	"log"
+	"sync"
+)
+
+// maxBatch is the most records an Async store hands to its backend at once
+const maxBatch = 256
+
+// Async is a Store that writes to a backend store from a background
+// goroutine, so appends never wait on disk
+type Async struct {
+	backend Store
+	pending chan Record
+	done    chan struct{}
+
+	mutex   sync.Mutex
+	closed  bool
+	dropped uint64
+}
+
+// NewAsync wraps the backend so appends are queued, holding up to size records
+func NewAsync(backend Store, size int) *Async {
+	a := &Async{
+		backend: backend,
+		pending: make(chan Record, size),
+		done:    make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// Append queues the records without blocking. Records that don't fit in the
+// queue are dropped and counted
+func (a *Async) Append(records ...Record) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+
+	for _, r := range records {
+		select {
+		case a.pending <- r:
+		default:
+			a.dropped++
+			if a.dropped == 1 || a.dropped%1000 == 0 {
+				log.Printf("History write queue full, %d records dropped", a.dropped)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Query reads from the backend. Records still queued are not included
+func (a *Async) Query(q Query) ([]Record, error) {
+	return a.backend.Query(q)
+}
+
+// Dropped returns the number of records dropped because the queue was full
+func (a *Async) Dropped() uint64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.dropped
+}
+
+// Close writes the queued records and closes the backend
+func (a *Async) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.pending)
+	a.mutex.Unlock()
+
+	<-a.done
+
+	return a.backend.Close()
+}
+
+// run writes queued records to the backend in batches
+func (a *Async) run() {
+	defer close(a.done)
+
+	batch := make([]Record, 0, maxBatch)
+
+	for r := range a.pending {
+		batch = append(batch[:0], r)
+
+		// Take whatever else is already queued
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case next, ok := <-a.pending:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		if err := a.backend.Append(batch...); err != nil {
+			log.Printf("Error writing %d history records: %v", len(batch), err)
+		}
+	}
+}
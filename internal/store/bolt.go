@@ -0,0 +1,160 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps the history in an embedded BoltDB file, with one bucket per
+// device keyed by receive time and sequence number so time range queries are
+// a single cursor scan
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates a BoltDB store at the path
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// boltKey orders records by receive time, then sequence number
+func boltKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+
+	return key
+}
+
+// Append writes the records in a single transaction
+func (s *BoltStore) Append(records ...Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, r := range records {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(r.DeviceID))
+			if err != nil {
+				return err
+			}
+
+			value, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put(boltKey(r.Time, r.Seq), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Query scans the device's bucket from the start of the time range, or from
+// its end when the newest records are wanted
+func (s *BoltStore) Query(q Query) ([]Record, error) {
+	if q.Newest && q.Limit > 0 {
+		return s.queryNewest(q)
+	}
+
+	var records []Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(q.DeviceID))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+
+		var key, value []byte
+		if q.From.IsZero() {
+			key, value = c.First()
+		} else {
+			key, value = c.Seek(boltKey(q.From, 0))
+		}
+
+		for ; key != nil; key, value = c.Next() {
+			var r Record
+			if err := json.Unmarshal(value, &r); err != nil {
+				return err
+			}
+
+			if !q.To.IsZero() && r.Time.After(q.To) {
+				break
+			}
+
+			records = append(records, r)
+
+			if q.Limit > 0 && len(records) >= q.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+// queryNewest scans the device's bucket backwards from the end of the time
+// range until it has found the query's limit of records
+func (s *BoltStore) queryNewest(q Query) ([]Record, error) {
+	var records []Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(q.DeviceID))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+
+		var key, value []byte
+		if q.To.IsZero() {
+			key, value = c.Last()
+		} else if key, _ = c.Seek(boltKey(q.To.Add(time.Nanosecond), 0)); key == nil {
+			key, value = c.Last()
+		} else {
+			key, value = c.Prev()
+		}
+
+		for ; key != nil; key, value = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(value, &r); err != nil {
+				return err
+			}
+
+			if !q.From.IsZero() && r.Time.Before(q.From) {
+				break
+			}
+
+			records = append(records, r)
+
+			if len(records) >= q.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	// Collected newest first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, err
+}
+
+// Close closes the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStoreQuery(t *testing.T) {
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		if err := s.Append(Record{DeviceID: "a", Channel: "fleet-a", Seq: uint64(i + 1), Time: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     Query
+		first     uint64
+		last      uint64
+		wantCount int
+	}{
+		{name: "everything", query: Query{DeviceID: "a"}, first: 1, last: 20, wantCount: 20},
+		{name: "limit", query: Query{DeviceID: "a", Limit: 3}, first: 1, last: 3, wantCount: 3},
+		{name: "newest", query: Query{DeviceID: "a", Limit: 3, Newest: true}, first: 18, last: 20, wantCount: 3},
+		{name: "newest up to", query: Query{DeviceID: "a", To: start.Add(9 * time.Minute), Limit: 4, Newest: true}, first: 7, last: 10, wantCount: 4},
+		{name: "newest in range", query: Query{DeviceID: "a", From: start.Add(8 * time.Minute), To: start.Add(9 * time.Minute), Limit: 4, Newest: true}, first: 9, last: 10, wantCount: 2},
+		{name: "newest past the end", query: Query{DeviceID: "a", To: start.Add(time.Hour), Limit: 2, Newest: true}, first: 19, last: 20, wantCount: 2},
+		{name: "unknown device", query: Query{DeviceID: "b", Limit: 3, Newest: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(records) != tt.wantCount {
+				t.Fatalf("got %d records, want %d", len(records), tt.wantCount)
+			}
+
+			if tt.wantCount > 0 && (records[0].Seq != tt.first || records[len(records)-1].Seq != tt.last) {
+				t.Errorf("got seq %d to %d, want %d to %d", records[0].Seq, records[len(records)-1].Seq, tt.first, tt.last)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the size at which a file store starts a new segment
+const DefaultSegmentSize = 64 << 20
+
+// segmentExt is the extension of segment files
+const segmentExt = ".jsonl"
+
+// FileStore is an append-only store of JSON lines split into segment files.
+// Each segment is named after the time of its first record, so queries only
+// read the segments that can overlap their time range
+type FileStore struct {
+	mutex       sync.Mutex
+	dir         string
+	segmentSize int64
+
+	// Start times of the segments, oldest first
+	segments []time.Time
+
+	// Currently written segment
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	closed bool
+}
+
+// OpenFileStore opens or creates a file store in the directory
+func OpenFileStore(dir string, segmentSize int64) (*FileStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{dir: dir, segmentSize: segmentSize}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, time.Unix(0, nanos))
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].Before(s.segments[j])
+	})
+
+	return s, nil
+}
+
+// segmentPath returns the path of the segment starting at the time
+func (s *FileStore) segmentPath(start time.Time) string {
+	return filepath.Join(s.dir, strconv.FormatInt(start.UnixNano(), 10)+segmentExt)
+}
+
+// Append writes the records to the current segment, starting a new one when it
+// is full
+func (s *FileStore) Append(records ...Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if s.file == nil || s.size+int64(len(line)) > s.segmentSize {
+			if err := s.rotate(r.Time); err != nil {
+				return err
+			}
+		}
+
+		n, err := s.writer.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.writer.Flush()
+}
+
+// rotate closes the current segment and starts a new one at the time
+func (s *FileStore) rotate(start time.Time) error {
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+
+	// Segment names must keep increasing even if the clock does not
+	if n := len(s.segments); n > 0 && !start.After(s.segments[n-1]) {
+		start = s.segments[n-1].Add(time.Nanosecond)
+	}
+
+	file, err := os.OpenFile(s.segmentPath(start), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.size = 0
+	s.segments = append(s.segments, start)
+
+	return nil
+}
+
+// closeSegment syncs and closes the current segment, if any
+func (s *FileStore) closeSegment() error {
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	s.writer = nil
+
+	return err
+}
+
+// Query scans the segments overlapping the time range for the device's records.
+// The scan runs without holding the store's lock so appends are never held up
+// by a long query. A line still being written is skipped like a torn write
+func (s *FileStore) Query(q Query) ([]Record, error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, ErrClosed
+	}
+
+	segments := make([]time.Time, len(s.segments))
+	copy(segments, s.segments)
+
+	// Appends are flushed as they are written, so the scan sees everything up to here
+	s.mutex.Unlock()
+
+	var selected []time.Time
+
+	for i, start := range segments {
+		// A segment ends where the next one starts
+		if !q.To.IsZero() && start.After(q.To) {
+			break
+		}
+		if !q.From.IsZero() && i+1 < len(segments) && segments[i+1].Before(q.From) {
+			continue
+		}
+
+		selected = append(selected, start)
+	}
+
+	if q.Newest && q.Limit > 0 {
+		return s.scanNewest(selected, q)
+	}
+
+	var records []Record
+
+	for _, start := range selected {
+		found, err := s.scan(start, q)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, found...)
+
+		if q.Limit > 0 && len(records) >= q.Limit {
+			return records[:q.Limit], nil
+		}
+	}
+
+	return records, nil
+}
+
+// scanNewest reads the segments newest first until it has found the query's
+// limit of records, and returns the newest of them oldest first
+func (s *FileStore) scanNewest(segments []time.Time, q Query) ([]Record, error) {
+	var records []Record
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		found, err := s.scan(segments[i], q)
+		if err != nil {
+			return nil, err
+		}
+		records = append(found, records...)
+
+		if len(records) >= q.Limit {
+			return records[len(records)-q.Limit:], nil
+		}
+	}
+
+	return records, nil
+}
+
+// scan reads the records of a segment selected by the query
+func (s *FileStore) scan(start time.Time, q Query) ([]Record, error) {
+	file, err := os.Open(s.segmentPath(start))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	// Cheap pre-filter before decoding each line
+	id, err := json.Marshal(q.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	needle := append([]byte(`"deviceId":`), id...)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.Contains(line, needle) {
+			continue
+		}
+
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			// A torn write at the end of a segment after a crash
+			continue
+		}
+
+		if q.matches(r) {
+			records = append(records, r)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// Close flushes and closes the current segment
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	return s.closeSegment()
+}
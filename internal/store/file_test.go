@@ -0,0 +1,107 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStoreQuery(t *testing.T) {
+	s, err := OpenFileStore(t.TempDir(), 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		for _, id := range []string{"a", "b"} {
+			err := s.Append(Record{DeviceID: id, Channel: "fleet-a", Seq: uint64(i + 1), Time: start.Add(time.Duration(i) * time.Minute)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(s.segments) < 2 {
+		t.Fatalf("expected the records to span several segments, got %d", len(s.segments))
+	}
+
+	tests := []struct {
+		name      string
+		query     Query
+		first     uint64
+		last      uint64
+		wantCount int
+	}{
+		{name: "everything", query: Query{DeviceID: "a"}, first: 1, last: 20, wantCount: 20},
+		{name: "time range", query: Query{DeviceID: "a", From: start.Add(5 * time.Minute), To: start.Add(9 * time.Minute)}, first: 6, last: 10, wantCount: 5},
+		{name: "limit", query: Query{DeviceID: "b", Limit: 3}, first: 1, last: 3, wantCount: 3},
+		{name: "newest", query: Query{DeviceID: "b", Limit: 3, Newest: true}, first: 18, last: 20, wantCount: 3},
+		{name: "newest in range", query: Query{DeviceID: "a", To: start.Add(9 * time.Minute), Limit: 4, Newest: true}, first: 7, last: 10, wantCount: 4},
+		{name: "unknown device", query: Query{DeviceID: "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(records) != tt.wantCount {
+				t.Fatalf("got %d records, want %d", len(records), tt.wantCount)
+			}
+
+			if tt.wantCount > 0 && (records[0].Seq != tt.first || records[len(records)-1].Seq != tt.last) {
+				t.Errorf("got seq %d to %d, want %d to %d", records[0].Seq, records[len(records)-1].Seq, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestFileStoreQueryDuringAppend(t *testing.T) {
+	s, err := OpenFileStore(t.TempDir(), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			if err := s.Append(Record{DeviceID: "a", Seq: uint64(i + 1), Time: start.Add(time.Duration(i) * time.Millisecond)}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Queries racing the writer only ever see whole records in order
+	for i := 0; i < 50; i++ {
+		records, err := s.Query(Query{DeviceID: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, r := range records {
+			if r.Seq != uint64(j+1) {
+				t.Fatalf("record %d has seq %d", j, r.Seq)
+			}
+		}
+	}
+
+	wg.Wait()
+
+	records, err := s.Query(Query{DeviceID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 500 {
+		t.Errorf("got %d records after the writer finished, want 500", len(records))
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned by stores that have been closed
+var ErrClosed = errors.New("store closed")
+
+// Record is a stored location update
+type Record struct {
+	DeviceID  string    `json:"deviceId"`
+	Channel   string    `json:"channel"`
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+
+	// Payload is the location as it was broadcast
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Motion and Route are the motion of the device and its progress along
+	// its route broadcast with the location, if any
+	Motion json.RawMessage `json:"motion,omitempty"`
+	Route  json.RawMessage `json:"route,omitempty"`
+}
+
+// Query selects the records of one device in a time range
+type Query struct {
+	DeviceID string
+
+	// From and To bound the server receive time, both inclusive. A zero value
+	// leaves that end open
+	From time.Time
+	To   time.Time
+
+	// Limit caps the number of records returned, 0 for no limit
+	Limit int
+
+	// Newest keeps the newest records instead of the oldest when there are
+	// more than Limit. They are still returned oldest first
+	Newest bool
+}
+
+// matches reports whether the record is selected by the query, ignoring the limit
+func (q Query) matches(r Record) bool {
+	if r.DeviceID != q.DeviceID {
+		return false
+	}
+
+	if !q.From.IsZero() && r.Time.Before(q.From) {
+		return false
+	}
+
+	if !q.To.IsZero() && r.Time.After(q.To) {
+		return false
+	}
+
+	return true
+}
+
+// Store keeps the location history of every device
+type Store interface {
+	// Append adds records to the history
+	Append(records ...Record) error
+
+	// Query returns the records selected by the query, oldest first
+	Query(q Query) ([]Record, error)
+
+	// Close flushes pending writes and releases the store
+	Close() error
+}
@@ -0,0 +1,257 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delivery defaults
+const (
+	DefaultMaxAttempts    = 6
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = 5 * time.Minute
+	DefaultTimeout        = 10 * time.Second
+
+	// DefaultMaxPending is the number of deliveries that may be in flight or
+	// waiting to be retried before new ones go straight to the dead-letter log
+	DefaultMaxPending = 10000
+)
+
+// maxResponseBody is how much of a failed response is kept for the dead-letter log
+const maxResponseBody = 1024
+
+// DeadLetter is a delivery that was given up on, written to the dead-letter log
+type DeadLetter struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error"`
+	Time           time.Time `json:"time"`
+}
+
+// Dispatcher POSTs events to the subscriptions that match them. Failed
+// deliveries are retried with exponential backoff and written to the
+// dead-letter log once they run out of attempts
+type Dispatcher struct {
+	Registry *Registry
+	Client   *http.Client
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxPending     int64
+
+	// DeadLetterPath is the JSON-lines file given-up deliveries are appended
+	// to. They are only logged when it is empty
+	DeadLetterPath string
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	pending int64
+
+	// closed is set by Close. Deliveries check it and join wg under
+	// closeMutex, so none starts after Close began waiting
+	closeMutex sync.Mutex
+	closed     bool
+
+	deadLetterMutex sync.Mutex
+}
+
+// NewDispatcher returns a dispatcher for the registry's subscriptions with the
+// default retry policy
+func NewDispatcher(registry *Registry, deadLetterPath string) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Dispatcher{
+		Registry:       registry,
+		Client:         &http.Client{Timeout: DefaultTimeout},
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		MaxPending:     DefaultMaxPending,
+		DeadLetterPath: deadLetterPath,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Publish delivers the event to every matching subscription in the background.
+// It never blocks
+func (d *Dispatcher) Publish(event Event) {
+	for _, s := range d.Registry.matching(event) {
+		d.Deliver(s, event)
+	}
+}
+
+// Deliver sends the event to one subscription in the background
+func (d *Dispatcher) Deliver(s Subscription, event Event) {
+	d.closeMutex.Lock()
+
+	if d.closed {
+		d.closeMutex.Unlock()
+		d.deadLetter(s, event, 0, fmt.Errorf("dispatcher closed"))
+		return
+	}
+
+	if atomic.AddInt64(&d.pending, 1) > d.MaxPending {
+		atomic.AddInt64(&d.pending, -1)
+		d.closeMutex.Unlock()
+		d.deadLetter(s, event, 0, fmt.Errorf("too many pending deliveries"))
+		return
+	}
+
+	d.wg.Add(1)
+	d.closeMutex.Unlock()
+
+	go func() {
+		defer d.wg.Done()
+		defer atomic.AddInt64(&d.pending, -1)
+
+		d.deliver(s, event)
+	}()
+}
+
+// Close stops retrying, writes the deliveries still waiting to the dead-letter
+// log and waits for deliveries in flight
+func (d *Dispatcher) Close() {
+	d.closeMutex.Lock()
+	d.closed = true
+	d.closeMutex.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// deliver POSTs the event until it is accepted or attempts run out
+func (d *Dispatcher) deliver(s Subscription, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.deadLetter(s, event, 0, err)
+		return
+	}
+
+	backoff := d.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(s, event, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= d.MaxAttempts {
+			d.deadLetter(s, event, attempt, err)
+			return
+		}
+
+		log.Printf("Webhook %s delivery of %s failed, retrying in %v: %v", s.ID, event.ID, backoff, err)
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-d.ctx.Done():
+			d.deadLetter(s, event, attempt, fmt.Errorf("dispatcher closed after: %v", err))
+			return
+		}
+
+		backoff *= 2
+		if backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (d *Dispatcher) post(s Subscription, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "locastream-webhook")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderSignature, Sign(s.Secret, time.Now(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(detail))
+
+	// Client errors other than rate limiting won't go away by retrying
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+
+	return retry, err
+}
+
+// deadLetter records a delivery that was given up on
+func (d *Dispatcher) deadLetter(s Subscription, event Event, attempts int, err error) {
+	log.Printf("Webhook %s gave up on delivery of %s %s after %d attempts: %v", s.ID, event.Type, event.ID, attempts, err)
+
+	if d.DeadLetterPath == "" {
+		return
+	}
+
+	line, merr := json.Marshal(DeadLetter{
+		SubscriptionID: s.ID,
+		URL:            s.URL,
+		Event:          event,
+		Attempts:       attempts,
+		Error:          err.Error(),
+		Time:           time.Now().UTC(),
+	})
+	if merr != nil {
+		log.Println("Error encoding dead letter:", merr)
+		return
+	}
+
+	d.deadLetterMutex.Lock()
+	defer d.deadLetterMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(d.DeadLetterPath), 0o755); err != nil {
+		log.Println("Error writing dead letter:", err)
+		return
+	}
+
+	f, err := os.OpenFile(d.DeadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Println("Error writing dead letter:", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Println("Error writing dead letter:", err)
+	}
+}
+
+// jitter spreads retries of deliveries that failed together over up to a
+// quarter of the backoff
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
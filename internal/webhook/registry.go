@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for subscriptions that don't exist
+var ErrNotFound = errors.New("webhook not found")
+
+// Registry holds the webhook subscriptions and saves them to a file
+type Registry struct {
+	mutex         sync.Mutex
+	path          string
+	subscriptions map[string]*Subscription
+}
+
+// Open loads the subscriptions saved in the file, which need not exist yet. An
+// empty path keeps subscriptions in memory only
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, subscriptions: make(map[string]*Subscription)}
+
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []*Subscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return nil, fmt.Errorf("error parsing webhooks file %s: %v", path, err)
+	}
+
+	for _, s := range subscriptions {
+		r.subscriptions[s.ID] = s
+	}
+
+	return r, nil
+}
+
+// Add validates and saves a new subscription, assigning it an ID
+func (r *Registry) Add(s Subscription) (Subscription, error) {
+	if err := s.Validate(); err != nil {
+		return Subscription{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	s.ID = id
+	s.CreatedAt = time.Now().UTC()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subscriptions[s.ID] = &s
+
+	if err := r.save(); err != nil {
+		delete(r.subscriptions, s.ID)
+		return Subscription{}, err
+	}
+
+	return s, nil
+}
+
+// Remove deletes a subscription
+func (r *Registry) Remove(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.subscriptions[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(r.subscriptions, id)
+
+	if err := r.save(); err != nil {
+		r.subscriptions[id] = s
+		return err
+	}
+
+	return nil
+}
+
+// Get returns a subscription by ID
+func (r *Registry) Get(id string) (Subscription, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.subscriptions[id]
+	if !ok {
+		return Subscription{}, false
+	}
+
+	return *s, true
+}
+
+// List returns the subscriptions that pass the filter, ordered by ID
+func (r *Registry) List(filter func(Subscription) bool) []Subscription {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	list := []Subscription{}
+	for _, s := range r.subscriptions {
+		if filter(*s) {
+			list = append(list, *s)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// matching returns the subscriptions the event is delivered to
+func (r *Registry) matching(event Event) []Subscription {
+	return r.List(func(s Subscription) bool {
+		return s.Matches(event)
+	})
+}
+
+// save writes the subscriptions to the registry's file, replacing it
+// atomically. The mutex must be held
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	subscriptions := make([]*Subscription, 0, len(r.subscriptions))
+	for _, s := range r.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	// The file holds signing secrets
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nihankhan/locastream/internal/auth"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Locastream-Event"
+	HeaderDelivery  = "X-Locastream-Delivery"
+	HeaderSignature = "X-Locastream-Signature"
+)
+
+// EventPing is sent to check that a subscription's endpoint is reachable
+const EventPing = "ping"
+
+// Event is the JSON body POSTed to subscribers
+type Event struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Channel string          `json:"channel,omitempty"`
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// NewEvent builds an event with a fresh ID, stamped with the current time
+func NewEvent(eventType, channel string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{ID: id, Type: eventType, Channel: channel, Time: time.Now().UTC(), Data: raw}, nil
+}
+
+// Subscription asks for events of some types to be POSTed to a URL
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+
+	// Events are the event types delivered. An entry ending in .* matches a
+	// whole family such as geofence.*, and * matches every event
+	Events []string `json:"events"`
+
+	// Channels limits deliveries to events on these channels and their event
+	// channels, e.g. fleet-a covers fleet-a:events. Empty for all
+	Channels []string `json:"channels,omitempty"`
+
+	// Secret signs every delivery
+	Secret string `json:"secret,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks that the subscription can be delivered to
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+
+	if len(s.Events) == 0 {
+		return fmt.Errorf("events must name at least one event type")
+	}
+
+	for _, e := range s.Events {
+		if e == "" || strings.Contains(strings.TrimSuffix(e, "*"), "*") {
+			return fmt.Errorf("invalid event type %q", e)
+		}
+	}
+
+	if s.Secret == "" {
+		return fmt.Errorf("secret must not be empty")
+	}
+
+	return nil
+}
+
+// Matches reports whether the event is delivered to the subscription. Pings
+// are only sent on request
+func (s *Subscription) Matches(event Event) bool {
+	if event.Type == EventPing {
+		return false
+	}
+
+	if len(s.Channels) > 0 && !covers(s.Channels, event.Channel) {
+		return false
+	}
+
+	for _, e := range s.Events {
+		switch {
+		case e == "*" || e == event.Type:
+			return true
+		case strings.HasSuffix(e, ".*") && strings.HasPrefix(event.Type, strings.TrimSuffix(e, "*")):
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sign returns the signature header value of a delivery body sent at the
+// time: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">
+func Sign(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// newID generates a random ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// covers reports whether the channel, or the channel it is the event channel
+// of, is in the list
+func covers(channels []string, channel string) bool {
+	for _, c := range channels {
+		if c == channel || c+auth.EventChannelSuffix == channel {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,323 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []string
+		channels []string
+		event    Event
+		want     bool
+	}{
+		{name: "exact type", events: []string{"device.offline"}, event: Event{Type: "device.offline"}, want: true},
+		{name: "other type", events: []string{"device.offline"}, event: Event{Type: "device.online"}},
+		{name: "family", events: []string{"geofence.*"}, event: Event{Type: "geofence.enter"}, want: true},
+		{name: "other family", events: []string{"geofence.*"}, event: Event{Type: "route.off-route"}},
+		{name: "everything", events: []string{"*"}, event: Event{Type: "route.off-route"}, want: true},
+		{name: "ping only on request", events: []string{"*"}, event: Event{Type: EventPing}},
+		{name: "channel", events: []string{"*"}, channels: []string{"fleet-a"}, event: Event{Type: "device.online", Channel: "fleet-a"}, want: true},
+		{name: "event channel", events: []string{"*"}, channels: []string{"fleet-a"}, event: Event{Type: "device.online", Channel: "fleet-a:events"}, want: true},
+		{name: "other channel named after it", events: []string{"*"}, channels: []string{"fleet-a"}, event: Event{Type: "device.online", Channel: "fleet-a:eu"}},
+		{name: "other channel", events: []string{"*"}, channels: []string{"fleet-a"}, event: Event{Type: "device.online", Channel: "fleet-b"}},
+		{name: "channel prefix lookalike", events: []string{"*"}, channels: []string{"fleet-a"}, event: Event{Type: "device.online", Channel: "fleet-ab"}},
+		{name: "all channels", events: []string{"*"}, event: Event{Type: "device.online", Channel: "fleet-b"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Subscription{Events: tt.events, Channels: tt.channels}
+			if got := s.Matches(tt.event); got != tt.want {
+				t.Errorf("Matches(%s on %q) = %v, want %v", tt.event.Type, tt.event.Channel, got, tt.want)
+			}
+		})
+	}
+}
+
+// attempt is a delivery received by a test server
+type attempt struct {
+	at        time.Time
+	header    http.Header
+	body      []byte
+	signature string
+}
+
+// testServer answers deliveries with the statuses in turn, repeating the last
+// one, and records what it received
+func testServer(t *testing.T, secret string, statuses ...int) (*httptest.Server, func() []attempt) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var attempts []attempt
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mutex.Lock()
+		a := attempt{at: time.Now(), header: r.Header.Clone(), body: body}
+
+		// Verify the signature the way a receiver would
+		if fields := strings.Split(r.Header.Get(HeaderSignature), ","); len(fields) == 2 && strings.HasPrefix(fields[0], "t=") {
+			if ts, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "t="), 10, 64); err == nil {
+				a.signature = Sign(secret, time.Unix(ts, 0), body)
+			}
+		}
+
+		attempts = append(attempts, a)
+		status := statuses[len(statuses)-1]
+		if len(attempts) <= len(statuses) {
+			status = statuses[len(attempts)-1]
+		}
+		mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []attempt {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return append([]attempt(nil), attempts...)
+	}
+}
+
+// testDispatcher returns a dispatcher with short backoffs that logs dead
+// letters to a temporary file
+func testDispatcher(t *testing.T) *Dispatcher {
+	t.Helper()
+
+	registry, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(registry, filepath.Join(t.TempDir(), "dead-letter.jsonl"))
+	d.MaxAttempts = 4
+	d.InitialBackoff = 20 * time.Millisecond
+	d.MaxBackoff = 40 * time.Millisecond
+
+	return d
+}
+
+// readDeadLetters reads the dead-letter log, which need not exist
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters
+}
+
+func TestDispatcherSignature(t *testing.T) {
+	const secret = "whsec_test"
+
+	server, attempts := testServer(t, secret, http.StatusNoContent)
+	d := testDispatcher(t)
+
+	if _, err := d.Registry.Add(Subscription{URL: server.URL, Events: []string{"geofence.*"}, Channels: []string{"fleet-a"}, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := NewEvent("geofence.enter", "fleet-a", map[string]string{"fenceId": "depot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Publish(event)
+	d.wg.Wait()
+
+	got := attempts()
+	if len(got) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(got))
+	}
+
+	a := got[0]
+	if a.header.Get(HeaderSignature) == "" || a.header.Get(HeaderSignature) != a.signature {
+		t.Errorf("signature %q does not verify, want %q", a.header.Get(HeaderSignature), a.signature)
+	}
+
+	if a.header.Get(HeaderEvent) != event.Type || a.header.Get(HeaderDelivery) != event.ID {
+		t.Errorf("got event %q delivery %q", a.header.Get(HeaderEvent), a.header.Get(HeaderDelivery))
+	}
+
+	var body Event
+	if err := json.Unmarshal(a.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID != event.ID || body.Type != event.Type || body.Channel != "fleet-a" {
+		t.Errorf("delivered %+v", body)
+	}
+
+	// Another secret doesn't verify
+	if Sign("whsec_other", time.Now(), a.body) == a.header.Get(HeaderSignature) {
+		t.Error("signature verified with the wrong secret")
+	}
+
+	if letters := readDeadLetters(t, d.DeadLetterPath); len(letters) != 0 {
+		t.Errorf("delivered event was dead-lettered: %+v", letters)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		attempts   int
+		deadLetter bool
+	}{
+		{name: "accepted after server errors", statuses: []int{500, 503, 429, 200}, attempts: 4},
+		{name: "gives up after max attempts", statuses: []int{502}, attempts: 4, deadLetter: true},
+		{name: "client error is not retried", statuses: []int{400}, attempts: 1, deadLetter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, attempts := testServer(t, "whsec_test", tt.statuses...)
+			d := testDispatcher(t)
+
+			s := Subscription{ID: "hook-1", URL: server.URL, Events: []string{"*"}, Secret: "whsec_test"}
+			event, err := NewEvent("device.offline", "fleet-a", map[string]string{"deviceId": "truck-42"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.Deliver(s, event)
+			d.wg.Wait()
+
+			got := attempts()
+			if len(got) != tt.attempts {
+				t.Fatalf("got %d attempts, want %d", len(got), tt.attempts)
+			}
+
+			// Backoff doubles from InitialBackoff up to MaxBackoff
+			wait := d.InitialBackoff
+			for i := 1; i < len(got); i++ {
+				if gap := got[i].at.Sub(got[i-1].at); gap < wait {
+					t.Errorf("attempt %d came %v after the previous one, want at least %v", i+1, gap, wait)
+				}
+
+				if got[i].header.Get(HeaderDelivery) != event.ID {
+					t.Errorf("attempt %d has delivery ID %q, want %q", i+1, got[i].header.Get(HeaderDelivery), event.ID)
+				}
+
+				if wait *= 2; wait > d.MaxBackoff {
+					wait = d.MaxBackoff
+				}
+			}
+
+			letters := readDeadLetters(t, d.DeadLetterPath)
+			if !tt.deadLetter {
+				if len(letters) != 0 {
+					t.Errorf("accepted delivery was dead-lettered: %+v", letters)
+				}
+				return
+			}
+
+			if len(letters) != 1 {
+				t.Fatalf("got %d dead letters, want 1", len(letters))
+			}
+
+			letter := letters[0]
+			if letter.SubscriptionID != s.ID || letter.URL != s.URL || letter.Event.ID != event.ID || letter.Attempts != tt.attempts || letter.Error == "" {
+				t.Errorf("unexpected dead letter %+v", letter)
+			}
+		})
+	}
+}
+
+func TestDispatcherClosed(t *testing.T) {
+	server, attempts := testServer(t, "whsec_test", http.StatusOK)
+	d := testDispatcher(t)
+	d.Close()
+
+	event, err := NewEvent("device.online", "fleet-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Deliver(Subscription{ID: "hook-1", URL: server.URL, Events: []string{"*"}, Secret: "whsec_test"}, event)
+
+	if got := attempts(); len(got) != 0 {
+		t.Errorf("closed dispatcher made %d attempts", len(got))
+	}
+
+	if letters := readDeadLetters(t, d.DeadLetterPath); len(letters) != 1 || letters[0].Attempts != 0 {
+		t.Errorf("got dead letters %+v, want one with no attempts", letters)
+	}
+}
+
+func TestDispatcherCloseWhileDelivering(t *testing.T) {
+	server, _ := testServer(t, "whsec_test", http.StatusOK)
+	d := testDispatcher(t)
+	d.DeadLetterPath = ""
+	d.MaxPending = 16
+	s := Subscription{ID: "hook-1", URL: server.URL, Events: []string{"*"}, Secret: "whsec_test"}
+
+	event, err := NewEvent("device.online", "fleet-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					d.Deliver(s, event)
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	d.Close()
+
+	// Close waited for every delivery that started, later ones are dead letters
+	if pending := atomic.LoadInt64(&d.pending); pending != 0 {
+		t.Errorf("%d deliveries pending after close", pending)
+	}
+
+	close(done)
+	wg.Wait()
+}